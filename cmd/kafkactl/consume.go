@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"jd.com/jvirt/jvirt-common/utils/kafka"
+)
+
+// 输出的消息格式
+type printedMessage struct {
+	Topic     string            `json:"topic"`
+	Partition int32             `json:"partition"`
+	Offset    int64             `json:"offset"`
+	Timestamp time.Time         `json:"timestamp"`
+	Key       string            `json:"key"`
+	Headers   map[string]string `json:"headers,omitempty"`
+	Value     interface{}       `json:"value"`
+}
+
+type printer struct {
+	mu     sync.Mutex
+	pretty bool
+	count  int
+	max    int
+	done   chan struct{}
+}
+
+func newPrinter(pretty bool, max int) *printer {
+	return &printer{
+		pretty: pretty,
+		max:    max,
+		done:   make(chan struct{}),
+	}
+}
+
+// 打印消息，value是合法的json时原样输出，否则作为字符串输出
+func (p *printer) print(msg *sarama.ConsumerMessage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.max > 0 && p.count >= p.max {
+		return
+	}
+
+	pm := &printedMessage{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Timestamp: msg.Timestamp,
+		Key:       string(msg.Key),
+		Headers:   kafka.Headers(msg),
+		Value:     string(msg.Value),
+	}
+	if json.Valid(msg.Value) {
+		pm.Value = json.RawMessage(msg.Value)
+	}
+
+	var data []byte
+	var err error
+	if p.pretty {
+		data, err = json.MarshalIndent(pm, "", "  ")
+	} else {
+		data, err = json.Marshal(pm)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Marshal message failed. Err: %v.\n", err)
+		return
+	}
+	fmt.Println(string(data))
+
+	p.count++
+	if p.max > 0 && p.count == p.max {
+		close(p.done)
+	}
+}
+
+// 等待打印够max条消息或收到退出信号
+func (p *printer) wait() {
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+
+	select {
+	case <-p.done:
+	case <-sig:
+	}
+}
+
+func runConsume(cfg *kafka.Config, args []string) error {
+	fs := flag.NewFlagSet("consume", flag.ExitOnError)
+	topic := fs.String("topic", "", "topic to consume, comma separated when consume with group")
+	group := fs.String("group", "", "consume with the group and commit offsets, otherwise read partitions directly")
+	offset := fs.String("offset", "newest", "start offset when no committed offset: oldest, newest or a number (without group only)")
+	partition := fs.Int("partition", -1, "only consume the partition, -1 consumes all partitions (without group only)")
+	max := fs.Int("n", 0, "exit after n messages, 0 means no limit (without group only)")
+	pretty := fs.Bool("pretty", false, "pretty print messages")
+	fs.Parse(args)
+	if *topic == "" {
+		return fmt.Errorf("topic not specified")
+	}
+
+	p := newPrinter(*pretty, *max)
+	if *group != "" {
+		// 消费组在退出前会继续标记已拉取的消息，提交的偏移量会超过打印的消息
+		if *max > 0 {
+			return fmt.Errorf("-n not support when consume with group")
+		}
+		return consumeWithGroup(cfg, splitList(*topic), *group, *offset, p)
+	}
+
+	start, err := parseOffset(*offset)
+	if err != nil {
+		return err
+	}
+	consumer, err := newConsumer(cfg)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	partitions, err := consumer.Partitions(*topic)
+	if err != nil {
+		return err
+	}
+	if *partition >= 0 {
+		partitions = []int32{int32(*partition)}
+	}
+	offsets := make(map[int32]int64, len(partitions))
+	for _, pid := range partitions {
+		offsets[pid] = start
+	}
+
+	return consumePartitions(consumer, *topic, offsets, p)
+}
+
+func consumeWithGroup(cfg *kafka.Config, topics []string, group, offset string, p *printer) error {
+	switch strings.ToLower(offset) {
+	case "oldest":
+		cfg.FromOffsets = "Oldest"
+	case "newest":
+		cfg.FromOffsets = "Newest"
+	default:
+		return fmt.Errorf("offset %s not support when consume with group", offset)
+	}
+	cfg.Topics = topics
+	cfg.GroupId = group
+
+	kcc, err := kafka.NewKafkaClusterConsumer(cfg)
+	if err != nil {
+		return err
+	}
+	defer kcc.Close()
+
+	kcc.CheckConsumeResult()
+	kcc.ListenMsg(p.print)
+	p.wait()
+
+	return nil
+}
+
+func parseOffset(offset string) (int64, error) {
+	switch strings.ToLower(offset) {
+	case "oldest":
+		return sarama.OffsetOldest, nil
+	case "newest":
+		return sarama.OffsetNewest, nil
+	}
+	n, err := strconv.ParseInt(offset, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid offset %s", offset)
+	}
+
+	return n, nil
+}
+
+func newConsumer(cfg *kafka.Config) (sarama.Consumer, error) {
+	config, err := cfg.BuildClientConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	return sarama.NewConsumer(cfg.Url, config)
+}
+
+// 从offsets指定的位置读取各个分区，不提交偏移量
+func consumePartitions(consumer sarama.Consumer, topic string, offsets map[int32]int64, p *printer) error {
+	pcs := make([]sarama.PartitionConsumer, 0, len(offsets))
+	defer func() {
+		for _, pc := range pcs {
+			pc.AsyncClose()
+		}
+	}()
+
+	for partition, offset := range offsets {
+		pc, err := consumer.ConsumePartition(topic, partition, offset)
+		if err != nil {
+			return err
+		}
+		pcs = append(pcs, pc)
+
+		go func(pc sarama.PartitionConsumer) {
+			for {
+				select {
+				case <-p.done:
+					return
+				case msg, ok := <-pc.Messages():
+					if !ok {
+						return
+					}
+					p.print(msg)
+				case err, ok := <-pc.Errors():
+					if !ok {
+						return
+					}
+					fmt.Fprintf(os.Stderr, "Consume partition failed. Err: %v.\n", err)
+				}
+			}
+		}(pc)
+	}
+	p.wait()
+
+	return nil
+}
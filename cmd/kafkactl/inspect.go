@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"jd.com/jvirt/jvirt-common/utils/kafka"
+)
+
+// 打印每个分区最后n条消息
+func runTail(cfg *kafka.Config, args []string) error {
+	fs := flag.NewFlagSet("tail", flag.ExitOnError)
+	topic := fs.String("topic", "", "topic to tail")
+	n := fs.Int64("n", 10, "number of messages of each partition")
+	partition := fs.Int("partition", -1, "only tail the partition, -1 tails all partitions")
+	pretty := fs.Bool("pretty", false, "pretty print messages")
+	timeout := fs.Duration("timeout", 10*time.Second, "max time waiting for messages of each partition")
+	fs.Parse(args)
+	if *topic == "" {
+		return fmt.Errorf("topic not specified")
+	}
+	if *n <= 0 {
+		return fmt.Errorf("n should be positive")
+	}
+
+	config, err := cfg.BuildClientConfig()
+	if err != nil {
+		return err
+	}
+	client, err := sarama.NewClient(cfg.Url, config)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		return err
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(*topic)
+	if err != nil {
+		return err
+	}
+	if *partition >= 0 {
+		partitions = []int32{int32(*partition)}
+	}
+
+	p := newPrinter(*pretty, 0)
+	for _, pid := range partitions {
+		oldest, err := client.GetOffset(*topic, pid, sarama.OffsetOldest)
+		if err != nil {
+			return err
+		}
+		newest, err := client.GetOffset(*topic, pid, sarama.OffsetNewest)
+		if err != nil {
+			return err
+		}
+		if newest <= oldest {
+			continue
+		}
+		start := newest - *n
+		if start < oldest {
+			start = oldest
+		}
+		if err := tailPartition(consumer, *topic, pid, start, newest, *timeout, p); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 读取[start, end)之间的消息，事务标记和compact会使实际的消息少于偏移量之差，读到end之前的最后一条或超时为止
+func tailPartition(consumer sarama.Consumer, topic string, partition int32, start, end int64,
+	timeout time.Duration, p *printer) error {
+	pc, err := consumer.ConsumePartition(topic, partition, start)
+	if err != nil {
+		return err
+	}
+	defer pc.Close()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			if msg.Offset >= end {
+				return nil
+			}
+			p.print(msg)
+			if msg.Offset >= end-1 {
+				return nil
+			}
+		case err := <-pc.Errors():
+			return err
+		case <-timer.C:
+			fmt.Fprintf(os.Stderr, "Tail partition %d timeout.\n", partition)
+			return nil
+		}
+	}
+}
+
+func runDescribe(cfg *kafka.Config, args []string) error {
+	fs := flag.NewFlagSet("describe", flag.ExitOnError)
+	topic := fs.String("topic", "", "topic to describe")
+	fs.Parse(args)
+	if *topic == "" {
+		return fmt.Errorf("topic not specified")
+	}
+
+	admin, err := kafka.NewKafkaAdmin(cfg)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	info, err := admin.DescribeTopic(*topic)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Topic: %s\tPartitions: %d\n", info.Name, len(info.Partitions))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "PARTITION\tLEADER\tREPLICAS\tISR\tOLDEST\tNEWEST\tMESSAGES")
+	for _, pi := range info.Partitions {
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%d\t%d\t%d\n", pi.Partition, pi.Leader, joinInt32(pi.Replicas),
+			joinInt32(pi.Isr), pi.OldestOffset, pi.NewestOffset, pi.NewestOffset-pi.OldestOffset)
+	}
+	w.Flush()
+
+	if len(info.Configs) > 0 {
+		names := make([]string, 0, len(info.Configs))
+		for name := range info.Configs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		fmt.Println("Configs:")
+		for _, name := range names {
+			fmt.Printf("  %s=%s\n", name, info.Configs[name])
+		}
+	}
+
+	return nil
+}
+
+func runLag(cfg *kafka.Config, args []string) error {
+	fs := flag.NewFlagSet("lag", flag.ExitOnError)
+	group := fs.String("group", "", "consumer group")
+	topic := fs.String("topic", "", "only show the topics, comma separated")
+	fs.Parse(args)
+	if *group == "" {
+		return fmt.Errorf("group not specified")
+	}
+
+	admin, err := kafka.NewKafkaAdmin(cfg)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	state, err := admin.GroupState(*group)
+	if err != nil {
+		return err
+	}
+	lags, err := admin.GroupLag(*group, splitList(*topic)...)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	fmt.Printf("Group: %s\tState: %s\n", *group, state)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TOPIC\tPARTITION\tCOMMITTED\tHIGH-WATERMARK\tLAG")
+	for _, lag := range lags {
+		committed := "-"
+		if lag.Committed >= 0 {
+			committed = fmt.Sprint(lag.Committed)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%d\n", lag.Topic, lag.Partition, committed, lag.HighWaterMark, lag.Lag)
+		total += lag.Lag
+	}
+	w.Flush()
+	fmt.Printf("Total lag: %d\n", total)
+
+	return nil
+}
+
+func runGroups(cfg *kafka.Config, args []string) error {
+	fs := flag.NewFlagSet("groups", flag.ExitOnError)
+	fs.Parse(args)
+
+	admin, err := kafka.NewKafkaAdmin(cfg)
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+
+	groups, err := admin.ListGroups()
+	if err != nil {
+		return err
+	}
+	for _, group := range groups {
+		fmt.Println(group)
+	}
+
+	return nil
+}
+
+func joinInt32(values []int32) string {
+	items := make([]string, 0, len(values))
+	for _, v := range values {
+		items = append(items, fmt.Sprint(v))
+	}
+
+	return strings.Join(items, ",")
+}
@@ -0,0 +1,148 @@
+// kafkactl 基于kafka包的命令行工具，用于值班时排查topic和消费组问题
+//
+//	kafkactl -brokers 10.0.0.1:9092 produce -topic t -key k -header trace_id=xxx < msg.json
+//	kafkactl -brokers 10.0.0.1:9092 consume -topic t -offset oldest -partition 0 -pretty
+//	kafkactl -brokers 10.0.0.1:9092 consume -topic t -group g
+//	kafkactl -brokers 10.0.0.1:9092 tail -topic t -n 10
+//	kafkactl -brokers 10.0.0.1:9092 replay -topic t -start 2021-03-01T10:00:00+08:00 -end 2021-03-01T10:30:00+08:00
+//	kafkactl -brokers 10.0.0.1:9092 describe -topic t
+//	kafkactl -brokers 10.0.0.1:9092 lag -group g
+//	kafkactl -config kafka.ini -section kafka groups
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"jd.com/jvirt/jvirt-common/utils/kafka"
+)
+
+type command struct {
+	name  string
+	usage string
+	run   func(cfg *kafka.Config, args []string) error
+}
+
+var commands = []*command{
+	{"produce", "produce messages from stdin or file", runProduce},
+	{"consume", "consume messages with group, offset and partition filters", runConsume},
+	{"tail", "print the last N messages of each partition", runTail},
+	{"replay", "print messages of a topic in a time range", runReplay},
+	{"describe", "describe topic partitions, offsets and configs", runDescribe},
+	{"lag", "show committed offset, high watermark and lag of a group", runLag},
+	{"groups", "list consumer groups", runGroups},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: kafkactl [global flags] <command> [flags]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nGlobal flags:\n")
+	flag.PrintDefaults()
+}
+
+func main() {
+	brokers := flag.String("brokers", os.Getenv("KAFKA_BROKERS"), "kafka brokers, comma separated")
+	configFile := flag.String("config", "", "config file (.ini, .yaml or .yml), overrides -brokers")
+	section := flag.String("section", "kafka", "section of the ini config file")
+	version := flag.String("version", "", "kafka version, e.g. 2.1.0")
+	debug := flag.Bool("debug", false, "print debug log")
+	flag.Usage = usage
+	flag.Parse()
+
+	if *debug {
+		log.SetLevel(log.DebugLevel)
+	} else {
+		log.SetLevel(log.WarnLevel)
+	}
+	if flag.NArg() < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	cfg, err := loadConfig(*configFile, *section, *brokers, *version)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Load config failed. Err: %v.\n", err)
+		os.Exit(1)
+	}
+
+	name := flag.Arg(0)
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		if err := c.run(cfg, flag.Args()[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%s failed. Err: %v.\n", name, err)
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "The %s command not support.\n", name)
+	usage()
+	os.Exit(2)
+}
+
+func loadConfig(file, section, brokers, version string) (*kafka.Config, error) {
+	var cfg *kafka.Config
+	var err error
+	switch strings.ToLower(filepath.Ext(file)) {
+	case "":
+		cfg = &kafka.Config{
+			Url:     splitList(brokers),
+			Version: version,
+		}
+		err = cfg.Validate()
+	case ".ini":
+		cfg, err = kafka.LoadConfigFromIni(file, section)
+	case ".yaml", ".yml":
+		cfg, err = kafka.LoadConfigFromYaml(file)
+	default:
+		return nil, fmt.Errorf("config file %s not support", file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Name == "" {
+		cfg.Name = "kafkactl"
+	}
+
+	return cfg, nil
+}
+
+func splitList(s string) []string {
+	items := make([]string, 0)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
+
+// 可重复的key=value参数
+type headerFlags map[string]string
+
+func (h headerFlags) String() string {
+	pairs := make([]string, 0, len(h))
+	for k, v := range h {
+		pairs = append(pairs, k+"="+v)
+	}
+
+	return strings.Join(pairs, ",")
+}
+
+func (h headerFlags) Set(value string) error {
+	kv := strings.SplitN(value, "=", 2)
+	if len(kv) != 2 || kv[0] == "" {
+		return fmt.Errorf("header %q should be key=value", value)
+	}
+	h[kv[0]] = kv[1]
+
+	return nil
+}
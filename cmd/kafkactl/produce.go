@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/Shopify/sarama"
+	"jd.com/jvirt/jvirt-common/utils/kafka"
+)
+
+func runProduce(cfg *kafka.Config, args []string) error {
+	fs := flag.NewFlagSet("produce", flag.ExitOnError)
+	topic := fs.String("topic", "", "topic to produce to")
+	key := fs.String("key", "", "message key")
+	file := fs.String("file", "", "read messages from file instead of stdin")
+	lines := fs.Bool("lines", false, "send each line as a message, otherwise the whole input is one message")
+	partition := fs.Int("partition", -1, "send to the partition, -1 uses the configured partitioner")
+	headers := headerFlags{}
+	fs.Var(headers, "header", "message header key=value, can be repeated")
+	fs.Parse(args)
+	if *topic == "" {
+		return fmt.Errorf("topic not specified")
+	}
+
+	var input io.Reader = os.Stdin
+	if *file != "" {
+		f, err := os.Open(*file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		input = f
+	}
+
+	if *partition >= 0 {
+		cfg.Partitioner = kafka.PartitionerManual
+	}
+	producer, err := kafka.NewKafkaSyncProducer(cfg)
+	if err != nil {
+		return err
+	}
+	defer producer.Close()
+
+	send := func(value []byte) error {
+		return producer.SendMessage(&kafka.Message{
+			Topic:     *topic,
+			Key:       *key,
+			Value:     sarama.ByteEncoder(value),
+			Headers:   headers,
+			Partition: int32(*partition),
+		})
+	}
+
+	count := 0
+	if *lines {
+		scanner := bufio.NewScanner(input)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) == 0 {
+				continue
+			}
+			if err := send(append([]byte(nil), line...)); err != nil {
+				return err
+			}
+			count++
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	} else {
+		value, err := ioutil.ReadAll(input)
+		if err != nil {
+			return err
+		}
+		if err := send(value); err != nil {
+			return err
+		}
+		count++
+	}
+	fmt.Fprintf(os.Stderr, "%d messages sent to %s.\n", count, *topic)
+
+	return nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"jd.com/jvirt/jvirt-common/utils/kafka"
+)
+
+func runReplay(cfg *kafka.Config, args []string) error {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	topic := fs.String("topic", "", "topic to replay")
+	start := fs.String("start", "", "start time in RFC3339, e.g. 2021-03-01T10:00:00+08:00")
+	end := fs.String("end", "", "end time in RFC3339, default now")
+	group := fs.String("group", "", "dedicated group to record replay progress, resume from it when replay again")
+	pretty := fs.Bool("pretty", false, "pretty print messages")
+	fs.Parse(args)
+	if *topic == "" || *start == "" {
+		return fmt.Errorf("topic and start not specified")
+	}
+
+	from, err := time.Parse(time.RFC3339, *start)
+	if err != nil {
+		return err
+	}
+	to := time.Now()
+	if *end != "" {
+		if to, err = time.Parse(time.RFC3339, *end); err != nil {
+			return err
+		}
+	}
+
+	cfg.GroupId = *group
+	replayer, err := kafka.NewReplayer(cfg)
+	if err != nil {
+		return err
+	}
+	defer replayer.Close()
+
+	// 收到退出信号时停止回放，已记录的进度会被提交
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sig)
+	go func() {
+		select {
+		case <-sig:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	p := newPrinter(*pretty, 0)
+	result, err := replayer.Replay(ctx, *topic, from, to, func(m *sarama.ConsumerMessage) error {
+		p.print(m)
+		return nil
+	})
+	if result != nil {
+		for _, rg := range result.Ranges {
+			fmt.Fprintf(os.Stderr, "partition %d: offset %d-%d, replayed %d, resumed %v\n",
+				rg.Partition, rg.Start, rg.End, result.Messages[rg.Partition], rg.Resumed)
+		}
+	}
+
+	return err
+}
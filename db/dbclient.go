@@ -55,6 +55,13 @@ func CreateDBClient(cfg *DBConfig) (*DBClient, error) {
 	return ret, nil
 }
 
+// 使用已有的连接池创建客户端, 用于共用连接或测试.
+func NewDBClient(db *sqlx.DB) *DBClient {
+	return &DBClient{
+		db: db,
+	}
+}
+
 // 向表中插入数据, 返回插入ID
 func (d *DBClient) Insert(insertSql string, args ...interface{}) (int64, error) {
 	stmt, err := d.db.Prepare(insertSql)
@@ -98,6 +105,7 @@ func (d *DBClient) Update(updateSql string, args ...interface{}) (int64, error)
 	return rows, nil
 }
 
+// 查询数据, 返回查询到的行数.
 func (d *DBClient) Query(querySql string, args ...interface{}) (int64, error) {
 	stmt, err := d.db.Prepare(querySql)
 	if stmt != nil {
@@ -110,4 +118,43 @@ func (d *DBClient) Query(querySql string, args ...interface{}) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
+	defer ret.Close()
+
+	var count int64
+	for ret.Next() {
+		count++
+	}
+
+	return count, ret.Err()
+}
+
+// 查询一行数据到dest, 没有数据时返回sql.ErrNoRows
+func (d *DBClient) Get(dest interface{}, querySql string, args ...interface{}) error {
+	return d.db.Get(dest, querySql, args...)
+}
+
+// 开启事务
+func (d *DBClient) Begin() (*sqlx.Tx, error) {
+	return d.db.Beginx()
+}
+
+// 在事务中执行fn, fn返回错误或panic时回滚, 否则提交.
+func (d *DBClient) Transaction(fn func(tx *sqlx.Tx) error) (err error) {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			tx.Rollback()
+			return
+		}
+		err = tx.Commit()
+	}()
+
+	return fn(tx)
 }
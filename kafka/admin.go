@@ -0,0 +1,353 @@
+package kafka
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 消费组在一个分区上的消费进度
+type PartitionLag struct {
+	Topic         string
+	Partition     int32
+	Committed     int64 // 已提交的偏移量，-1表示未提交过
+	HighWaterMark int64 // 分区下一条消息的偏移量
+	Lag           int64 // 未消费的消息数，未提交过时按分区最早的消息计算
+}
+
+// kafka管理客户端，用于查看消费进度和重置消费组偏移量
+type KafkaAdmin struct {
+	client sarama.Client
+	admin  sarama.ClusterAdmin
+}
+
+func NewKafkaAdmin(cfg *Config) (*KafkaAdmin, error) {
+	config, err := cfg.BuildClientConfig()
+	if err != nil {
+		log.Errorf("Invalid admin config. Error: %#v.", err)
+		return nil, err
+	}
+	client, err := sarama.NewClient(cfg.Url, config)
+	if err != nil {
+		log.Errorf("Invoke NewClient failed. Error: %#v.", err)
+		return nil, err
+	}
+	admin, err := sarama.NewClusterAdminFromClient(client)
+	if err != nil {
+		log.Errorf("Invoke NewClusterAdminFromClient failed. Error: %#v.", err)
+		client.Close()
+		return nil, err
+	}
+
+	return &KafkaAdmin{
+		client: client,
+		admin:  admin,
+	}, nil
+}
+
+// 关闭管理客户端，同时关闭底层的连接
+func (ka *KafkaAdmin) Close() error {
+	return ka.admin.Close()
+}
+
+// 列出所有消费组
+func (ka *KafkaAdmin) ListGroups() ([]string, error) {
+	groups, err := ka.admin.ListConsumerGroups()
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([]string, 0, len(groups))
+	for name := range groups {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names, nil
+}
+
+// 查询消费组的状态，如Stable、Empty、Dead、PreparingRebalance
+func (ka *KafkaAdmin) GroupState(groupId string) (string, error) {
+	descs, err := ka.admin.DescribeConsumerGroups([]string{groupId})
+	if err != nil {
+		return "", err
+	}
+	if len(descs) == 0 {
+		return "", fmt.Errorf("group %s not found", groupId)
+	}
+	if descs[0].Err != sarama.ErrNoError {
+		return "", descs[0].Err
+	}
+
+	return descs[0].State, nil
+}
+
+// 查询消费组在各个分区上的消费进度，topics为空时查询该消费组提交过偏移量的所有topic
+func (ka *KafkaAdmin) GroupLag(groupId string, topics ...string) ([]*PartitionLag, error) {
+	var topicPartitions map[string][]int32
+	if len(topics) > 0 {
+		topicPartitions = make(map[string][]int32, len(topics))
+		for _, topic := range topics {
+			partitions, err := ka.client.Partitions(topic)
+			if err != nil {
+				return nil, err
+			}
+			topicPartitions[topic] = partitions
+		}
+	}
+
+	resp, err := ka.admin.ListConsumerGroupOffsets(groupId, topicPartitions)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Err != sarama.ErrNoError {
+		return nil, resp.Err
+	}
+
+	lags := make([]*PartitionLag, 0)
+	for topic, blocks := range resp.Blocks {
+		for partition, block := range blocks {
+			if block.Err != sarama.ErrNoError {
+				return nil, block.Err
+			}
+			lag, err := ka.partitionLag(topic, partition, block.Offset)
+			if err != nil {
+				return nil, err
+			}
+			lags = append(lags, lag)
+		}
+	}
+	sort.Slice(lags, func(i, j int) bool {
+		if lags[i].Topic != lags[j].Topic {
+			return lags[i].Topic < lags[j].Topic
+		}
+		return lags[i].Partition < lags[j].Partition
+	})
+
+	return lags, nil
+}
+
+func (ka *KafkaAdmin) partitionLag(topic string, partition int32, committed int64) (*PartitionLag, error) {
+	hwm, err := ka.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	if err != nil {
+		return nil, err
+	}
+
+	lag := &PartitionLag{
+		Topic:         topic,
+		Partition:     partition,
+		Committed:     committed,
+		HighWaterMark: hwm,
+	}
+	if committed >= 0 {
+		lag.Lag = hwm - committed
+		return lag, nil
+	}
+	oldest, err := ka.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	if err != nil {
+		return nil, err
+	}
+	lag.Lag = hwm - oldest
+
+	return lag, nil
+}
+
+// 将消费组在topic所有分区上的偏移量重置到最早的消息，返回重置后的偏移量
+func (ka *KafkaAdmin) ResetOffsetsToEarliest(groupId, topic string) (map[int32]int64, error) {
+	return ka.resetOffsets(groupId, topic, func(partition int32) (int64, error) {
+		return ka.client.GetOffset(topic, partition, sarama.OffsetOldest)
+	})
+}
+
+// 将消费组在topic所有分区上的偏移量重置到最新的消息之后，返回重置后的偏移量
+func (ka *KafkaAdmin) ResetOffsetsToLatest(groupId, topic string) (map[int32]int64, error) {
+	return ka.resetOffsets(groupId, topic, func(partition int32) (int64, error) {
+		return ka.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	})
+}
+
+// 将消费组在topic所有分区上的偏移量重置到t之后的第一条消息，没有时重置到最新，返回重置后的偏移量
+func (ka *KafkaAdmin) ResetOffsetsToTime(groupId, topic string, t time.Time) (map[int32]int64, error) {
+	return ka.resetOffsets(groupId, topic, func(partition int32) (int64, error) {
+		return ka.offsetForTime(topic, partition, t)
+	})
+}
+
+// 将消费组在topic指定分区上的偏移量重置为offsets，未指定的分区不变
+func (ka *KafkaAdmin) ResetOffsets(groupId, topic string, offsets map[int32]int64) (map[int32]int64, error) {
+	partitions := make([]int32, 0, len(offsets))
+	for partition := range offsets {
+		partitions = append(partitions, partition)
+	}
+
+	return ka.resetPartitionOffsets(groupId, topic, partitions, func(partition int32) (int64, error) {
+		return offsets[partition], nil
+	})
+}
+
+// 查询分区中时间不早于t的第一条消息的偏移量，没有时返回最新偏移量
+func (ka *KafkaAdmin) offsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	offset, err := ka.client.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return 0, err
+	}
+	if offset < 0 {
+		return ka.client.GetOffset(topic, partition, sarama.OffsetNewest)
+	}
+
+	return offset, nil
+}
+
+func (ka *KafkaAdmin) resetOffsets(groupId, topic string, offsetFunc func(partition int32) (int64, error)) (map[int32]int64, error) {
+	partitions, err := ka.client.Partitions(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	return ka.resetPartitionOffsets(groupId, topic, partitions, offsetFunc)
+}
+
+// 重置偏移量前消费组必须没有活跃的消费者，否则提交的偏移量会被消费者覆盖
+func (ka *KafkaAdmin) resetPartitionOffsets(groupId, topic string, partitions []int32,
+	offsetFunc func(partition int32) (int64, error)) (map[int32]int64, error) {
+	state, err := ka.GroupState(groupId)
+	if err != nil {
+		return nil, err
+	}
+	if state != "Empty" && state != "Dead" {
+		return nil, fmt.Errorf("group %s is %s, stop all consumers before reset offsets", groupId, state)
+	}
+
+	offsets := make(map[int32]int64, len(partitions))
+	for _, partition := range partitions {
+		offset, err := offsetFunc(partition)
+		if err != nil {
+			return nil, err
+		}
+		offsets[partition] = offset
+	}
+
+	if err := ka.commitOffsets(groupId, topic, offsets); err != nil {
+		return nil, err
+	}
+	log.Infof("Reset group offsets success. GroupID: %s, Topic: %s, Offsets: %v.", groupId, topic, offsets)
+
+	return offsets, nil
+}
+
+// 直接向消费组的协调者提交偏移量，偏移量可以比当前提交的大或小
+// 以空的成员ID和未定义的generation提交，只有消费组没有活跃成员时broker才会接受
+func (ka *KafkaAdmin) commitOffsets(groupId, topic string, offsets map[int32]int64) error {
+	coordinator, err := ka.client.Coordinator(groupId)
+	if err != nil {
+		log.Errorf("Invoke Coordinator failed. GroupID: %s, Error: %#v.", groupId, err)
+		return err
+	}
+
+	req := &sarama.OffsetCommitRequest{
+		Version:                 1,
+		ConsumerGroup:           groupId,
+		ConsumerGroupGeneration: sarama.GroupGenerationUndefined,
+	}
+	if ka.client.Config().Version.IsAtLeast(sarama.V0_9_0_0) {
+		req.Version = 2
+		req.RetentionTime = -1 // 使用broker配置的保留时间
+	}
+	for partition, offset := range offsets {
+		req.AddBlock(topic, partition, offset, sarama.ReceiveTime, "")
+	}
+	resp, err := coordinator.CommitOffset(req)
+	if err != nil {
+		log.Errorf("Invoke CommitOffset failed. GroupID: %s, Error: %#v.", groupId, err)
+		return err
+	}
+	for partition, offset := range offsets {
+		kerr, ok := resp.Errors[topic][partition]
+		if !ok {
+			log.Errorf("Commit offset response missing partition. GroupID: %s, Topic: %s, Partition: %v.", groupId, topic, partition)
+			return sarama.ErrIncompleteResponse
+		}
+		if kerr != sarama.ErrNoError {
+			log.Errorf("Commit offset failed. GroupID: %s, Topic: %s, Partition: %v, Offset: %v, Error: %v.",
+				groupId, topic, partition, offset, kerr)
+			return fmt.Errorf("reset offset of %s/%d to %d failed: %v", topic, partition, offset, kerr)
+		}
+	}
+
+	return nil
+}
+
+// 分区信息
+type PartitionInfo struct {
+	Partition    int32
+	Leader       int32
+	Replicas     []int32
+	Isr          []int32
+	OldestOffset int64
+	NewestOffset int64
+}
+
+// topic信息
+type TopicInfo struct {
+	Name       string
+	Partitions []*PartitionInfo
+	Configs    map[string]string // 非默认值的topic配置
+}
+
+// 查询topic的分区、副本、偏移量和配置
+func (ka *KafkaAdmin) DescribeTopic(topic string) (*TopicInfo, error) {
+	metas, err := ka.admin.DescribeTopics([]string{topic})
+	if err != nil {
+		return nil, err
+	}
+	if len(metas) == 0 {
+		return nil, fmt.Errorf("topic %s not found", topic)
+	}
+	if metas[0].Err != sarama.ErrNoError {
+		return nil, metas[0].Err
+	}
+
+	info := &TopicInfo{
+		Name:    topic,
+		Configs: make(map[string]string),
+	}
+	for _, pm := range metas[0].Partitions {
+		oldest, err := ka.client.GetOffset(topic, pm.ID, sarama.OffsetOldest)
+		if err != nil {
+			return nil, err
+		}
+		newest, err := ka.client.GetOffset(topic, pm.ID, sarama.OffsetNewest)
+		if err != nil {
+			return nil, err
+		}
+		info.Partitions = append(info.Partitions, &PartitionInfo{
+			Partition:    pm.ID,
+			Leader:       pm.Leader,
+			Replicas:     pm.Replicas,
+			Isr:          pm.Isr,
+			OldestOffset: oldest,
+			NewestOffset: newest,
+		})
+	}
+	sort.Slice(info.Partitions, func(i, j int) bool {
+		return info.Partitions[i].Partition < info.Partitions[j].Partition
+	})
+
+	entries, err := ka.admin.DescribeConfig(sarama.ConfigResource{
+		Type: sarama.TopicResource,
+		Name: topic,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if !entry.Default {
+			info.Configs[entry.Name] = entry.Value
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,99 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func newAdminBroker(t *testing.T, committed int64, commitErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetController(broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorGroup, "g", broker),
+		"DescribeGroupsRequest": sarama.NewMockDescribeGroupsResponse(t).
+			AddGroupDescription("g", &sarama.GroupDescription{GroupId: "g", State: "Empty"}),
+		"OffsetFetchRequest": sarama.NewMockOffsetFetchResponse(t).
+			SetOffset("g", "orders", 0, committed, "", sarama.ErrNoError).
+			SetOffset("g", "orders", 1, committed, "", sarama.ErrNoError),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, sarama.OffsetNewest, 100).
+			SetOffset("orders", 1, sarama.OffsetNewest, 40),
+		"OffsetCommitRequest": sarama.NewMockOffsetCommitResponse(t).
+			SetError("g", "orders", 1, commitErr),
+	})
+
+	return broker
+}
+
+// broker收到的最后一次提交的偏移量
+func lastCommitted(t *testing.T, broker *sarama.MockBroker, partition int32) int64 {
+	history := broker.History()
+	for i := len(history) - 1; i >= 0; i-- {
+		if req, ok := history[i].Request.(*sarama.OffsetCommitRequest); ok {
+			offset, _, err := req.Offset("orders", partition)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return offset
+		}
+	}
+	t.Fatal("no offset commit request")
+
+	return 0
+}
+
+func TestResetOffsets(t *testing.T) {
+	cases := []struct {
+		name      string
+		committed int64
+		reset     func(ka *KafkaAdmin) (map[int32]int64, error)
+		want      map[int32]int64
+	}{
+		{"backward", 50, func(ka *KafkaAdmin) (map[int32]int64, error) {
+			return ka.ResetOffsets("g", "orders", map[int32]int64{0: 10})
+		}, map[int32]int64{0: 10}},
+		{"forward", 50, func(ka *KafkaAdmin) (map[int32]int64, error) {
+			return ka.ResetOffsets("g", "orders", map[int32]int64{0: 80})
+		}, map[int32]int64{0: 80}},
+		{"never committed", -1, func(ka *KafkaAdmin) (map[int32]int64, error) {
+			return ka.ResetOffsetsToLatest("g", "orders")
+		}, map[int32]int64{0: 100, 1: 40}},
+	}
+	for _, c := range cases {
+		broker := newAdminBroker(t, c.committed, sarama.ErrNoError)
+		ka, err := NewKafkaAdmin(&Config{Url: []string{broker.Addr()}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		offsets, err := c.reset(ka)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		for partition, want := range c.want {
+			if offsets[partition] != want || lastCommitted(t, broker, partition) != want {
+				t.Errorf("%s: partition %d reset to %d, committed %d, want %d",
+					c.name, partition, offsets[partition], lastCommitted(t, broker, partition), want)
+			}
+		}
+		ka.Close()
+		broker.Close()
+	}
+
+	// broker拒绝提交时返回错误
+	broker := newAdminBroker(t, 50, sarama.ErrUnknownMemberId)
+	defer broker.Close()
+	ka, err := NewKafkaAdmin(&Config{Url: []string{broker.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ka.Close()
+	if _, err := ka.ResetOffsetsToLatest("g", "orders"); err == nil {
+		t.Error("rejected commit should fail")
+	}
+}
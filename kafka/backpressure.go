@@ -0,0 +1,187 @@
+package kafka
+
+import (
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+	"github.com/bsm/sarama-cluster"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// 暂停拉取指定分区的消息，rebalance后重新分配到的分区仍保持暂停，已拉取到队列中的消息仍会被处理
+func (kcc *KafkaClusterConsumer) Pause(partitions map[string][]int32) {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			kcc.paused[topicPartition{topic, p}] = true
+		}
+	}
+	kcc.applyPause()
+}
+
+// 恢复拉取指定分区的消息，PauseAll暂停的分区需要通过ResumeAll恢复
+func (kcc *KafkaClusterConsumer) Resume(partitions map[string][]int32) {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+	for topic, ps := range partitions {
+		for _, p := range ps {
+			delete(kcc.paused, topicPartition{topic, p})
+		}
+	}
+	kcc.applyPause()
+}
+
+// 暂停拉取所有分区的消息
+func (kcc *KafkaClusterConsumer) PauseAll() {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+	kcc.pausedAll = true
+	kcc.applyPause()
+}
+
+// 恢复拉取所有分区的消息，包括通过Pause暂停的分区
+func (kcc *KafkaClusterConsumer) ResumeAll() {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+	kcc.pausedAll = false
+	kcc.paused = make(map[topicPartition]bool)
+	kcc.applyPause()
+}
+
+// 分区是否暂停拉取，包括队列满时的自动暂停
+func (kcc *KafkaClusterConsumer) IsPaused(topic string, partition int32) bool {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+
+	return kcc.shouldPause(topicPartition{topic, partition})
+}
+
+func (kcc *KafkaClusterConsumer) shouldPause(tp topicPartition) bool {
+	return kcc.pausedAll || kcc.throttled || kcc.paused[tp]
+}
+
+// 按当前状态暂停或恢复已分配的分区，调用方持有pmu
+func (kcc *KafkaClusterConsumer) applyPause() {
+	for tp, pc := range kcc.pcs {
+		if kcc.shouldPause(tp) {
+			pc.Pause()
+		} else {
+			pc.Resume()
+		}
+	}
+}
+
+// 队列满时自动暂停所有分区，处理到队列剩一半时恢复
+func (kcc *KafkaClusterConsumer) setThrottled(throttled bool) {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+	if kcc.throttled == throttled {
+		return
+	}
+	kcc.throttled = throttled
+	if throttled {
+		log.Warnf("KafkaClusterConsumer queue is full, pause fetching. GroupID: %s, QueueSize: %d.", kcc.groupId, cap(kcc.queue))
+	} else {
+		log.Infof("KafkaClusterConsumer queue drained, resume fetching. GroupID: %s.", kcc.groupId)
+	}
+	kcc.applyPause()
+}
+
+// 从队列取出消息后调用
+func (kcc *KafkaClusterConsumer) checkThrottle() {
+	if len(kcc.queue) > cap(kcc.queue)/2 {
+		return
+	}
+	kcc.pmu.Lock()
+	throttled := kcc.throttled
+	kcc.pmu.Unlock()
+	if throttled {
+		kcc.setThrottled(false)
+	}
+}
+
+// 接收分配到的分区并拉取消息放入队列，只启动一次
+func (kcc *KafkaClusterConsumer) startFetch() {
+	kcc.fetchOnce.Do(func() {
+		kcc.wg.Add(1)
+		go func() {
+			defer kcc.wg.Done()
+
+			for {
+				select {
+				case <-kcc.done:
+					log.Info("KafkaClusterConsumer stop, Fetch exit.")
+					return
+				case pc, ok := <-kcc.consumer.Partitions():
+					if !ok {
+						return
+					}
+					kcc.addPartition(pc)
+					kcc.wg.Add(1)
+					go kcc.fetchPartition(pc)
+				}
+			}
+		}()
+	})
+}
+
+func (kcc *KafkaClusterConsumer) addPartition(pc cluster.PartitionConsumer) {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+
+	tp := topicPartition{pc.Topic(), pc.Partition()}
+	kcc.pcs[tp] = pc
+	kcc.health.assign(tp)
+	if kcc.shouldPause(tp) {
+		pc.Pause()
+	}
+}
+
+func (kcc *KafkaClusterConsumer) removePartition(pc cluster.PartitionConsumer) {
+	kcc.pmu.Lock()
+	defer kcc.pmu.Unlock()
+
+	tp := topicPartition{pc.Topic(), pc.Partition()}
+	if kcc.pcs[tp] == pc {
+		delete(kcc.pcs, tp)
+		kcc.health.release(tp)
+	}
+}
+
+// 分区被回收或消费者关闭时退出
+func (kcc *KafkaClusterConsumer) fetchPartition(pc cluster.PartitionConsumer) {
+	defer kcc.wg.Done()
+	defer kcc.removePartition(pc)
+
+	for {
+		select {
+		case <-kcc.done:
+			return
+		case msg, ok := <-pc.Messages():
+			if !ok {
+				log.Debugf("KafkaClusterConsumer partition released. Topic: %s, Partition: %v.", pc.Topic(), pc.Partition())
+				return
+			}
+			kcc.health.fetched(msg)
+			kcc.enqueue(msg)
+		}
+	}
+}
+
+func (kcc *KafkaClusterConsumer) enqueue(msg *sarama.ConsumerMessage) {
+	select {
+	case kcc.queue <- msg:
+		return
+	default:
+	}
+
+	kcc.setThrottled(true)
+	select {
+	case kcc.queue <- msg:
+	case <-kcc.done:
+	}
+}
@@ -0,0 +1,79 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+)
+
+type fakePartitionConsumer struct {
+	sarama.PartitionConsumer
+	topic     string
+	partition int32
+	paused    bool
+}
+
+func (pc *fakePartitionConsumer) Topic() string             { return pc.topic }
+func (pc *fakePartitionConsumer) Partition() int32          { return pc.partition }
+func (pc *fakePartitionConsumer) InitialOffset() int64      { return 0 }
+func (pc *fakePartitionConsumer) MarkOffset(int64, string)  {}
+func (pc *fakePartitionConsumer) ResetOffset(int64, string) {}
+func (pc *fakePartitionConsumer) Pause()                    { pc.paused = true }
+func (pc *fakePartitionConsumer) Resume()                   { pc.paused = false }
+func (pc *fakePartitionConsumer) IsPaused() bool            { return pc.paused }
+
+func TestConsumerPause(t *testing.T) {
+	kcc := &KafkaClusterConsumer{
+		done:   make(chan struct{}),
+		queue:  make(chan *sarama.ConsumerMessage, 4),
+		pcs:    make(map[topicPartition]cluster.PartitionConsumer),
+		paused: make(map[topicPartition]bool),
+	}
+	p0 := &fakePartitionConsumer{topic: "t", partition: 0}
+	p1 := &fakePartitionConsumer{topic: "t", partition: 1}
+	kcc.addPartition(p0)
+	kcc.addPartition(p1)
+
+	kcc.Pause(map[string][]int32{"t": {1}})
+	if p0.paused || !p1.paused {
+		t.Fatalf("p0 = %v, p1 = %v", p0.paused, p1.paused)
+	}
+
+	// 重新分配的分区保持暂停
+	kcc.removePartition(p1)
+	p1 = &fakePartitionConsumer{topic: "t", partition: 1}
+	kcc.addPartition(p1)
+	if !p1.paused || !kcc.IsPaused("t", 1) {
+		t.Fatal("reassigned partition should stay paused")
+	}
+
+	kcc.PauseAll()
+	kcc.Resume(map[string][]int32{"t": {1}})
+	if !p0.paused || !p1.paused {
+		t.Fatal("PauseAll should keep all partitions paused")
+	}
+	kcc.ResumeAll()
+	if p0.paused || p1.paused {
+		t.Fatal("ResumeAll should resume all partitions")
+	}
+
+	// 队列满时自动暂停，取出到一半时恢复
+	for i := 0; i < 4; i++ {
+		kcc.enqueue(&sarama.ConsumerMessage{})
+	}
+	go kcc.enqueue(&sarama.ConsumerMessage{})
+	for !kcc.IsPaused("t", 0) {
+	}
+	<-kcc.queue
+	kcc.checkThrottle()
+	if !p0.paused {
+		t.Fatal("should stay paused while queue is more than half full")
+	}
+	<-kcc.queue
+	<-kcc.queue
+	kcc.checkThrottle()
+	if p0.paused {
+		t.Fatal("should resume after queue drained")
+	}
+}
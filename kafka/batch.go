@@ -0,0 +1,137 @@
+package kafka
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	batchRetryBackoff    = time.Second      // 批量处理失败后首次重试的间隔，之后每次翻倍
+	batchMaxRetryBackoff = 30 * time.Second // 批量处理失败后最大重试间隔
+)
+
+// 同一个分区待处理的一批消息
+type pendingBatch struct {
+	msgs    []*sarama.ConsumerMessage
+	started time.Time // 第一条消息加入的时间
+}
+
+// 批量接收消息：按分区积累消息，达到maxSize条或第一条消息等待超过maxWait时调用handler
+// handler返回错误或panic时重试同一批消息，成功后才标记偏移量，关闭时未处理完的消息会被重新消费
+// Use添加的中间件对批量处理不生效，SetClaimCheck设置的大消息存储生效
+func (kcc *KafkaClusterConsumer) ListenBatch(handler func(msgs []*sarama.ConsumerMessage) error, maxSize int, maxWait time.Duration) {
+	kcc.mu.Lock()
+	defer kcc.mu.Unlock()
+	if kcc.running || kcc.stopErr != nil {
+		return
+	}
+	kcc.running = true
+	if maxSize <= 0 {
+		maxSize = 1
+	}
+	if maxWait <= 0 {
+		maxWait = time.Second
+	}
+	kcc.startFetch()
+
+	kcc.wg.Add(1)
+	go func() {
+		defer kcc.wg.Done()
+
+		tick := maxWait / 2
+		if tick < 10*time.Millisecond {
+			tick = 10 * time.Millisecond
+		}
+		ticker := time.NewTicker(tick)
+		defer ticker.Stop()
+
+		batches := make(map[topicPartition]*pendingBatch)
+		for {
+			select {
+			case <-kcc.done:
+				log.Info("KafkaClusterConsumer stop, ListenBatch exit.")
+				return
+			case msg := <-kcc.queue:
+				kcc.checkThrottle()
+				tp := topicPartition{msg.Topic, msg.Partition}
+				b, ok := batches[tp]
+				if !ok {
+					b = &pendingBatch{msgs: make([]*sarama.ConsumerMessage, 0, maxSize), started: time.Now()}
+					batches[tp] = b
+				}
+				b.msgs = append(b.msgs, msg)
+				if len(b.msgs) >= maxSize {
+					delete(batches, tp)
+					if !kcc.consumeBatch(handler, b.msgs) {
+						return
+					}
+				}
+			case <-ticker.C:
+				for tp, b := range batches {
+					if time.Since(b.started) < maxWait {
+						continue
+					}
+					delete(batches, tp)
+					if !kcc.consumeBatch(handler, b.msgs) {
+						return
+					}
+				}
+			}
+		}
+	}()
+}
+
+// 处理一批消息直到成功，返回false表示消费者已关闭
+func (kcc *KafkaClusterConsumer) consumeBatch(handler func(msgs []*sarama.ConsumerMessage) error, msgs []*sarama.ConsumerMessage) bool {
+	first, last := msgs[0], msgs[len(msgs)-1]
+	log.Debugf("KafkaClusterConsumer ConsumeBatch: GroupID: %s, Topic: %s, Partition: %v, Offset: %v-%v",
+		kcc.groupId, first.Topic, first.Partition, first.Offset, last.Offset)
+	atomic.AddInt32(&kcc.inflight, int32(len(msgs)))
+	defer atomic.AddInt32(&kcc.inflight, -int32(len(msgs)))
+	kcc.health.begin(time.Now())
+	defer kcc.health.end()
+
+	backoff := batchRetryBackoff
+	for {
+		start := time.Now()
+		err := resolveClaimChecks(kcc.claimStore, msgs)
+		if err == nil {
+			err = callBatchHandler(handler, msgs)
+		}
+		if err == nil {
+			duration := time.Since(start) / time.Duration(len(msgs))
+			for _, msg := range msgs {
+				kcc.observeConsume(msg, duration)
+			}
+			kcc.consumer.MarkOffset(last, "")
+			return true
+		}
+
+		kcc.observeError()
+		log.Errorf("KafkaClusterConsumer consume batch failed, retry after %v. Topic: %s, Partition: %v, Offset: %v-%v, Error: %v.",
+			backoff, first.Topic, first.Partition, first.Offset, last.Offset, err)
+		select {
+		case <-kcc.done:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > batchMaxRetryBackoff {
+			backoff = batchMaxRetryBackoff
+		}
+	}
+}
+
+// 将handler的panic转换为错误
+func callBatchHandler(handler func(msgs []*sarama.ConsumerMessage) error, msgs []*sarama.ConsumerMessage) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("batch handler panic: %v", p)
+		}
+	}()
+
+	return handler(msgs)
+}
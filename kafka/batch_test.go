@@ -0,0 +1,121 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+)
+
+// 记录标记的偏移量，不拉取消息，消息直接放入队列
+type fakeClusterConsumer struct {
+	mu     sync.Mutex
+	marked []int64
+}
+
+func (c *fakeClusterConsumer) Partitions() <-chan cluster.PartitionConsumer { return nil }
+func (c *fakeClusterConsumer) Notifications() <-chan *cluster.Notification  { return nil }
+func (c *fakeClusterConsumer) Errors() <-chan error                         { return nil }
+func (c *fakeClusterConsumer) HighWaterMarks() map[string]map[int32]int64   { return nil }
+func (c *fakeClusterConsumer) Close() error                                 { return nil }
+func (c *fakeClusterConsumer) MarkOffset(msg *sarama.ConsumerMessage, _ string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.marked = append(c.marked, msg.Offset)
+}
+
+func (c *fakeClusterConsumer) markedOffsets() []int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return append([]int64(nil), c.marked...)
+}
+
+func newBatchConsumer() (*KafkaClusterConsumer, *fakeClusterConsumer) {
+	fc := &fakeClusterConsumer{}
+	return &KafkaClusterConsumer{
+		consumer: fc,
+		done:     make(chan struct{}),
+		queue:    make(chan *sarama.ConsumerMessage, 16),
+		pcs:      make(map[topicPartition]cluster.PartitionConsumer),
+		paused:   make(map[topicPartition]bool),
+	}, fc
+}
+
+func TestListenBatch(t *testing.T) {
+	kcc, fc := newBatchConsumer()
+	defer kcc.Shutdown(context.Background())
+	batches := make(chan []*sarama.ConsumerMessage, 4)
+	kcc.ListenBatch(func(msgs []*sarama.ConsumerMessage) error {
+		batches <- msgs
+		return nil
+	}, 3, 100*time.Millisecond)
+
+	// 达到maxSize条时立即处理，不同分区分别积累
+	start := time.Now()
+	for i := int64(0); i < 3; i++ {
+		kcc.queue <- &sarama.ConsumerMessage{Topic: "t", Partition: 0, Offset: i}
+	}
+	kcc.queue <- &sarama.ConsumerMessage{Topic: "t", Partition: 1, Offset: 7}
+	if b := <-batches; len(b) != 3 || b[2].Offset != 2 || time.Since(start) >= 100*time.Millisecond {
+		t.Fatalf("size window: %d msgs after %v", len(b), time.Since(start))
+	}
+
+	// 不足maxSize条时等待maxWait后处理
+	if b := <-batches; len(b) != 1 || b[0].Partition != 1 || time.Since(start) < 100*time.Millisecond {
+		t.Fatalf("time window: %d msgs after %v", len(b), time.Since(start))
+	}
+	if marked := fc.markedOffsets(); len(marked) != 2 || marked[0] != 2 || marked[1] != 7 {
+		t.Errorf("marked = %v", marked)
+	}
+}
+
+func TestListenBatchRetry(t *testing.T) {
+	kcc, fc := newBatchConsumer()
+	defer kcc.Shutdown(context.Background())
+	var mu sync.Mutex
+	calls := 0
+	kcc.ListenBatch(func(msgs []*sarama.ConsumerMessage) error {
+		mu.Lock()
+		defer mu.Unlock()
+		calls++
+		if calls == 1 {
+			panic("bad batch")
+		}
+		return nil
+	}, 2, time.Hour)
+
+	kcc.queue <- &sarama.ConsumerMessage{Topic: "t", Offset: 0}
+	kcc.queue <- &sarama.ConsumerMessage{Topic: "t", Offset: 1}
+
+	// panic视为失败，重试成功前不标记偏移量
+	time.Sleep(batchRetryBackoff / 2)
+	if marked := fc.markedOffsets(); len(marked) != 0 {
+		t.Fatalf("marked before batch succeeded: %v", marked)
+	}
+	deadline := time.Now().Add(2 * batchRetryBackoff)
+	for len(fc.markedOffsets()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if marked := fc.markedOffsets(); calls != 2 || len(marked) != 1 || marked[0] != 1 {
+		t.Errorf("calls = %d, marked = %v", calls, marked)
+	}
+}
+
+func TestCallBatchHandler(t *testing.T) {
+	failed := errors.New("failed")
+	if err := callBatchHandler(func([]*sarama.ConsumerMessage) error { return failed }, nil); err != failed {
+		t.Errorf("err = %v", err)
+	}
+	err := callBatchHandler(func([]*sarama.ConsumerMessage) error { panic("boom") }, nil)
+	if err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Errorf("panic err = %v", err)
+	}
+}
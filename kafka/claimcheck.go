@@ -0,0 +1,214 @@
+package kafka
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	ClaimCheckHeader = "kafka_claim_check" // 消息值为大消息内容的引用
+
+	defaultClaimCheckThreshold = 900000 // 略小于默认的max.message.bytes
+)
+
+var ErrClaimCheckMismatch = errors.New("kafka: claim check payload size or checksum mismatch")
+
+// 保存大消息内容的存储，如对象存储、共享文件系统
+// imagestore.StoreDriver没有读写数据的接口，需要包装为BlobStore使用
+type BlobStore interface {
+	// 保存内容，返回读取时使用的位置
+	Put(key string, data []byte) (location string, err error)
+	Get(location string) ([]byte, error)
+}
+
+// 发送到kafka的引用消息
+type claimReference struct {
+	Location string `json:"location"`
+	Size     int    `json:"size"`
+	Checksum string `json:"checksum"` // sha256
+}
+
+type claimCheck struct {
+	store     BlobStore
+	threshold int
+}
+
+// 设置大消息的存储：消息值超过threshold字节时内容保存到store，只发送包含位置和校验和的引用
+// threshold不大于0时为900000字节，消费者通过SetClaimCheck设置同一个存储后读取内容
+// 存储中的内容不会自动删除，需要配置比topic保留时间更长的过期策略
+func (ksp *KafkaSyncProducer) SetClaimCheck(store BlobStore, threshold int) {
+	ksp.claimCheck = newClaimCheck(store, threshold)
+}
+
+func (kap *KafkaAsyncProducer) SetClaimCheck(store BlobStore, threshold int) {
+	kap.claimCheck = newClaimCheck(store, threshold)
+}
+
+func newClaimCheck(store BlobStore, threshold int) *claimCheck {
+	if store == nil {
+		return nil
+	}
+	if threshold <= 0 {
+		threshold = defaultClaimCheckThreshold
+	}
+
+	return &claimCheck{
+		store:     store,
+		threshold: threshold,
+	}
+}
+
+// 消息值超过阈值时保存到存储并替换为引用，未设置时不做任何事
+func (c *claimCheck) apply(msg *sarama.ProducerMessage) error {
+	if c == nil || msg.Value == nil || msg.Value.Length() <= c.threshold {
+		return nil
+	}
+
+	data, err := msg.Value.Encode()
+	if err != nil {
+		return err
+	}
+	id, err := newCorrelationId()
+	if err != nil {
+		return err
+	}
+	location, err := c.store.Put(msg.Topic+"/"+id, data)
+	if err != nil {
+		log.Errorf("Save claim check payload failed. Topic: %s, Size: %d, Error: %#v.", msg.Topic, len(data), err)
+		return err
+	}
+	sum := sha256.Sum256(data)
+	ref, err := json.Marshal(&claimReference{
+		Location: location,
+		Size:     len(data),
+		Checksum: hex.EncodeToString(sum[:]),
+	})
+	if err != nil {
+		return err
+	}
+	msg.Value = sarama.ByteEncoder(ref)
+	msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(ClaimCheckHeader), Value: []byte("sha256")})
+	log.Debugf("Save claim check payload success. Topic: %s, Size: %d, Location: %s.", msg.Topic, len(data), location)
+
+	return nil
+}
+
+// 消息为引用时从存储中读取内容并校验，替换消息的Value并去掉引用的消息头，不是引用时不做任何事
+func ResolveClaimCheck(store BlobStore, m *sarama.ConsumerMessage) error {
+	if Header(m, ClaimCheckHeader) == "" {
+		return nil
+	}
+
+	ref := &claimReference{}
+	if err := json.Unmarshal(m.Value, ref); err != nil {
+		log.Errorf("Invalid claim check reference. Topic: %s, Offset: %v, Error: %#v.", m.Topic, m.Offset, err)
+		return err
+	}
+	data, err := store.Get(ref.Location)
+	if err != nil {
+		log.Errorf("Load claim check payload failed. Location: %s, Error: %#v.", ref.Location, err)
+		return err
+	}
+	sum := sha256.Sum256(data)
+	if len(data) != ref.Size || hex.EncodeToString(sum[:]) != ref.Checksum {
+		log.Errorf("Claim check payload mismatch. Location: %s, Size: %d, Expected: %d.", ref.Location, len(data), ref.Size)
+		return ErrClaimCheckMismatch
+	}
+	m.Value = data
+	// 重试时不再重复读取
+	headers := m.Headers[:0]
+	for _, h := range m.Headers {
+		if h == nil || string(h.Key) != ClaimCheckHeader {
+			headers = append(headers, h)
+		}
+	}
+	m.Headers = headers
+
+	return nil
+}
+
+// 读取一批消息中的大消息内容，有一条失败时返回错误
+func resolveClaimChecks(store BlobStore, msgs []*sarama.ConsumerMessage) error {
+	if store == nil {
+		return nil
+	}
+	for _, m := range msgs {
+		if err := ResolveClaimCheck(store, m); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 设置读取大消息内容的存储，与生产者的SetClaimCheck对应，需要在ListenMsg、ListenBatch或ListenTxnMsg之前调用
+// ListenMsg读取失败时记录日志后跳过该消息，需要转发到死信topic时改用Use(ClaimCheck(store, onError))
+// ListenBatch和ListenTxnMsg读取失败时与处理失败相同，间隔一段时间后重试
+func (kcc *KafkaClusterConsumer) SetClaimCheck(store BlobStore) {
+	kcc.mu.Lock()
+	defer kcc.mu.Unlock()
+	kcc.claimStore = store
+}
+
+// 读取失败时跳过消息
+func skipClaimCheck(m *sarama.ConsumerMessage, err error) {
+	log.Errorf("Skip claim check msg. Topic: %s, Partition: %v, Offset: %v, Error: %v.", m.Topic, m.Partition, m.Offset, err)
+}
+
+// 消费大消息：处理函数收到的是从存储读取并校验后的内容，用于MemoryConsumer等没有SetClaimCheck的消费者
+// 读取或校验失败时不调用处理函数，调用onError（如转发到死信topic），onError返回后偏移量会被标记
+// onError为nil时记录日志后跳过该消息
+func ClaimCheck(store BlobStore, onError func(m *sarama.ConsumerMessage, err error)) Middleware {
+	if onError == nil {
+		onError = skipClaimCheck
+	}
+
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *sarama.ConsumerMessage) {
+			if err := ResolveClaimCheck(store, m); err != nil {
+				onError(m, err)
+				return
+			}
+			next(m)
+		}
+	}
+}
+
+// 进程内的BlobStore，用于单元测试
+type MemoryBlobStore struct {
+	mu    sync.Mutex
+	blobs map[string][]byte
+}
+
+func NewMemoryBlobStore() *MemoryBlobStore {
+	return &MemoryBlobStore{
+		blobs: make(map[string][]byte),
+	}
+}
+
+func (s *MemoryBlobStore) Put(key string, data []byte) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	location := "memory://" + key
+	s.blobs[location] = append([]byte(nil), data...)
+
+	return location, nil
+}
+
+func (s *MemoryBlobStore) Get(location string) ([]byte, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	data, ok := s.blobs[location]
+	if !ok {
+		return nil, fmt.Errorf("blob %s not found", location)
+	}
+
+	return append([]byte(nil), data...), nil
+}
@@ -0,0 +1,123 @@
+package kafka
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 模拟经过kafka后消费到的消息
+func consumed(t *testing.T, msg *sarama.ProducerMessage) *sarama.ConsumerMessage {
+	value, err := msg.Value.Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := &sarama.ConsumerMessage{Topic: msg.Topic, Value: value}
+	for i := range msg.Headers {
+		cm.Headers = append(cm.Headers, &msg.Headers[i])
+	}
+
+	return cm
+}
+
+func TestClaimCheck(t *testing.T) {
+	store := NewMemoryBlobStore()
+	cc := newClaimCheck(store, 16)
+
+	small, err := (&Message{Topic: "images", Value: sarama.StringEncoder("small")}).encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.apply(small); err != nil {
+		t.Fatal(err)
+	}
+	if len(small.Headers) != 0 {
+		t.Errorf("small message should not be claim checked")
+	}
+
+	payload := []byte(strings.Repeat("x", 1024))
+	large, err := (&Message{Topic: "images", Value: sarama.ByteEncoder(payload)}).encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cc.apply(large); err != nil {
+		t.Fatal(err)
+	}
+	if large.Value.Length() >= len(payload) {
+		t.Fatalf("large message not replaced, size %d", large.Value.Length())
+	}
+
+	var received []byte
+	var failed error
+	onError := func(m *sarama.ConsumerMessage, err error) { failed = err }
+	handler := Chain(func(m *sarama.ConsumerMessage) { received = m.Value }, ClaimCheck(store, onError))
+	handler(consumed(t, large))
+	if !bytes.Equal(received, payload) || failed != nil {
+		t.Errorf("received %d bytes, want %d, err = %v", len(received), len(payload), failed)
+	}
+
+	// 内容被篡改时不调用处理函数
+	ref := consumed(t, large)
+	for location := range store.blobs {
+		store.blobs[location] = []byte(strings.Repeat("y", 1024))
+	}
+	received = nil
+	handler(ref)
+	if received != nil || failed != ErrClaimCheckMismatch {
+		t.Errorf("received = %d bytes, err = %v", len(received), failed)
+	}
+
+	// 未指定onError时跳过消息
+	received = nil
+	Chain(func(m *sarama.ConsumerMessage) { received = m.Value }, ClaimCheck(store, nil))(consumed(t, large))
+	if received != nil {
+		t.Errorf("received = %d bytes", len(received))
+	}
+}
+
+func TestConsumerClaimCheck(t *testing.T) {
+	store := NewMemoryBlobStore()
+	fake := &fakeSyncProducer{}
+	ksp := &KafkaSyncProducer{sp: fake}
+	ksp.SetClaimCheck(store, 16)
+	payload := strings.Repeat("x", 1024)
+	for i := 0; i < 3; i++ {
+		if err := ksp.Send("images", "k", sarama.StringEncoder(payload)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 关闭后不再保存内容
+	ksp.closed = true
+	if err := ksp.Send("images", "k", sarama.StringEncoder(payload)); err != ErrProducerClosed || len(store.blobs) != 3 {
+		t.Errorf("err = %v, blobs = %d", err, len(store.blobs))
+	}
+
+	// ListenMsg和ListenBatch处理前读取内容
+	kcc, _ := newBatchConsumer()
+	kcc.SetClaimCheck(store)
+	received := make(chan string, 1)
+	kcc.ListenMsg(func(m *sarama.ConsumerMessage) { received <- string(m.Value) })
+	kcc.queue <- consumed(t, fake.sent[0])
+	if got := <-received; got != payload {
+		t.Errorf("ListenMsg received %d bytes", len(got))
+	}
+	kcc.Close()
+
+	kcc, _ = newBatchConsumer()
+	kcc.SetClaimCheck(store)
+	kcc.ListenBatch(func(msgs []*sarama.ConsumerMessage) error {
+		for _, m := range msgs {
+			received <- string(m.Value)
+		}
+		return nil
+	}, 1, time.Hour)
+	kcc.queue <- consumed(t, fake.sent[1])
+	if got := <-received; got != payload {
+		t.Errorf("ListenBatch received %d bytes", len(got))
+	}
+	kcc.Close()
+}
@@ -0,0 +1,379 @@
+package kafka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+	"gopkg.in/ini.v1"
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultRetryMax     = 5
+	defaultRetryBackoff = 100 * time.Millisecond
+)
+
+// 生产者、消费者和管理客户端共用的配置，未配置的项使用默认值
+// 支持从ini、yaml文件和环境变量加载，环境变量名为前缀加ini名的大写，如KAFKA_ACK_RULE
+type Config struct {
+	Name     string         `ini:"name" yaml:"name"`                 //客户端名称，用于监控查看问题
+	Url      []string       `ini:"url" yaml:"url" delim:","`         //可多个，逗号分隔
+	Version  string         `ini:"version" yaml:"version"`           //kafka版本，如2.1.0，默认使用sarama的默认版本
+	Security SecurityConfig `ini:"-" yaml:"security" env:"SECURITY"` //安全配置：TLS和SASL认证，默认明文连接，ini中为<section>.security小节
+
+	Topics            []string      `ini:"topics" yaml:"topics" delim:","`               //可多个，逗号分隔
+	GroupId           string        `ini:"group_id" yaml:"group_id"`                     //消费组
+	FromOffsets       string        `ini:"from_offsets" yaml:"from_offsets"`             //消费配置：偏移量，支持（Newest，Oldest）二种，默认使用Oldest
+	CommitInterval    time.Duration `ini:"commit_interval" yaml:"commit_interval"`       //消费配置：多久提交一次偏移量，默认1秒一次
+	IsolationLevel    string        `ini:"isolation_level" yaml:"isolation_level"`       //消费配置：事务隔离级别，支持（ReadUncommitted，ReadCommitted）二种，默认使用ReadUncommitted
+	FetchMin          int32         `ini:"fetch_min" yaml:"fetch_min"`                   //消费配置：单次拉取最小字节数，默认1
+	FetchDefault      int32         `ini:"fetch_default" yaml:"fetch_default"`           //消费配置：单次拉取每个分区的字节数，默认1MB
+	FetchMax          int32         `ini:"fetch_max" yaml:"fetch_max"`                   //消费配置：单次拉取每个分区的最大字节数，默认不限制
+	MaxWaitTime       time.Duration `ini:"max_wait_time" yaml:"max_wait_time"`           //消费配置：拉取时broker最多等待时间，默认250毫秒
+	SessionTimeout    time.Duration `ini:"session_timeout" yaml:"session_timeout"`       //消费配置：消费组会话超时时间，默认30秒
+	HeartbeatInterval time.Duration `ini:"heartbeat_interval" yaml:"heartbeat_interval"` //消费配置：消费组心跳间隔，默认3秒
+	QueueSize         int           `ini:"queue_size" yaml:"queue_size"`                 //消费配置：待处理消息队列长度，队列满时暂停拉取，默认256
+	StallTimeout      time.Duration `ini:"stall_timeout" yaml:"stall_timeout"`           //消费配置：健康检查：有待处理的消息但超过该时间没有处理完成时认为消费卡住，默认5分钟
+
+	AckRule         string        `ini:"ack_rule" yaml:"ack_rule"`                   //发送配置：ack规则（NoResponse、WaitForLocal、WaitForAll）默认为WaitForLocal
+	AckTimeout      time.Duration `ini:"ack_timeout" yaml:"ack_timeout"`             //发送配置：等待Ack最大时间，默认10秒
+	Partitioner     string        `ini:"partitioner" yaml:"partitioner"`             //发送配置：分区策略（Hash、Murmur2、RoundRobin、Manual）默认为Hash
+	Idempotent      bool          `ini:"idempotent" yaml:"idempotent"`               //发送配置：幂等发送，开启后AckRule固定为WaitForAll，重试不会产生重复消息
+	TransactionalId string        `ini:"transactional_id" yaml:"transactional_id"`   //发送配置：事务ID，配置后开启事务（自动开启幂等发送），同一个ID同时只能有一个生产者实例
+	Compression     string        `ini:"compression" yaml:"compression"`             //发送配置：压缩算法（none、gzip、snappy、lz4、zstd）默认为none
+	MaxMessageBytes int           `ini:"max_message_bytes" yaml:"max_message_bytes"` //发送配置：单条消息最大字节数，默认1000000
+	FlushBytes      int           `ini:"flush_bytes" yaml:"flush_bytes"`             //发送配置：积累多少字节后发送一批，默认不限制
+	FlushMessages   int           `ini:"flush_messages" yaml:"flush_messages"`       //发送配置：积累多少条消息后发送一批，默认不限制
+	FlushFrequency  time.Duration `ini:"flush_frequency" yaml:"flush_frequency"`     //发送配置：多久发送一批，默认不等待
+	RetryMax        int           `ini:"retry_max" yaml:"retry_max"`                 //发送配置：失败重试次数，默认5次，小于0时不重试
+	RetryBackoff    time.Duration `ini:"retry_backoff" yaml:"retry_backoff"`         //发送配置：重试间隔，默认100毫秒
+
+	Metrics *Metrics `ini:"-" yaml:"-" env:"-"` //监控指标，多个客户端可共用，为nil时不统计
+}
+
+// 兼容已有的配置类型
+type ConsumerConfig = Config
+type ProducerConfig = Config
+
+var (
+	ackRules        = []string{"", "NoResponse", "WaitForLocal", "WaitForAll"}
+	fromOffsets     = []string{"", "Newest", "Oldest"}
+	isolationLevels = []string{"", "ReadUncommitted", "ReadCommitted"}
+	partitioners    = []string{"", PartitionerHash, PartitionerMurmur2, PartitionerRoundRobin, PartitionerManual}
+	compressions    = []string{"", "none", "gzip", "snappy", "lz4", "zstd"}
+	saslMechanisms  = []string{"", SASLPlain, SASLScramSHA256, SASLScramSHA512}
+)
+
+// 从ini文件的section小节加载配置，安全配置从<section>.security小节加载
+func LoadConfigFromIni(file, section string) (*Config, error) {
+	f, err := ini.Load(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := f.Section(section).MapTo(cfg); err != nil {
+		return nil, err
+	}
+	if s, err := f.GetSection(section + ".security"); err == nil {
+		if err := s.MapTo(&cfg.Security); err != nil {
+			return nil, err
+		}
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// 从yaml文件加载配置
+func LoadConfigFromYaml(file string) (*Config, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// 从环境变量加载配置，如prefix为KAFKA时从KAFKA_URL、KAFKA_GROUP_ID、KAFKA_SECURITY_SASL_USER等加载
+func LoadConfigFromEnv(prefix string) (*Config, error) {
+	cfg := &Config{}
+	if err := loadEnv(reflect.ValueOf(cfg).Elem(), strings.ToUpper(prefix)); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func loadEnv(v reflect.Value, prefix string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("env")
+		if name == "" {
+			name = field.Tag.Get("ini")
+		}
+		if name == "" || name == "-" {
+			continue
+		}
+		name = strings.ToUpper(name)
+		if prefix != "" {
+			name = prefix + "_" + name
+		}
+
+		fv := v.Field(i)
+		if fv.Kind() == reflect.Struct {
+			if err := loadEnv(fv, name); err != nil {
+				return err
+			}
+			continue
+		}
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		if err := setField(fv, value); err != nil {
+			return fmt.Errorf("invalid env %s: %v", name, err)
+		}
+	}
+
+	return nil
+}
+
+func setField(fv reflect.Value, value string) error {
+	switch fv.Interface().(type) {
+	case string:
+		fv.SetString(value)
+	case []string:
+		items := make([]string, 0)
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items))
+	case bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		fv.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		fv.SetInt(int64(d))
+	case int, int32:
+		n, err := strconv.ParseInt(value, 10, fv.Type().Bits())
+		if err != nil {
+			return err
+		}
+		fv.SetInt(n)
+	default:
+		return fmt.Errorf("type %s not support", fv.Type())
+	}
+
+	return nil
+}
+
+// 校验配置，不支持的枚举值返回错误
+func (c *Config) Validate() error {
+	if len(c.Url) == 0 {
+		return fmt.Errorf("url not configured")
+	}
+	if c.Version != "" {
+		if _, err := sarama.ParseKafkaVersion(c.Version); err != nil {
+			return err
+		}
+	}
+
+	enums := []struct {
+		name   string
+		value  string
+		values []string
+	}{
+		{"AckRule", c.AckRule, ackRules},
+		{"FromOffsets", c.FromOffsets, fromOffsets},
+		{"IsolationLevel", c.IsolationLevel, isolationLevels},
+		{"Partitioner", c.Partitioner, partitioners},
+		{"Compression", c.Compression, compressions},
+		{"SASLMechanism", c.Security.SASLMechanism, saslMechanisms},
+	}
+	for _, e := range enums {
+		if !contains(e.values, e.value) {
+			return fmt.Errorf("invalid %s %q, support %s", e.name, e.value, strings.Join(e.values[1:], ","))
+		}
+	}
+
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// 生成客户端公共的sarama配置，用于管理客户端等
+func (c *Config) BuildClientConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	if err := c.applyClientConfig(config); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
+func (c *Config) applyClientConfig(config *sarama.Config) error {
+	if err := c.Validate(); err != nil {
+		return err
+	}
+
+	config.ClientID = c.Name
+	if c.Version != "" {
+		version, _ := sarama.ParseKafkaVersion(c.Version)
+		config.Version = version
+	}
+
+	return applySecurity(config, &c.Security)
+}
+
+// 生成生产者的sarama配置
+func (c *Config) BuildProducerConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	if err := c.applyClientConfig(config); err != nil {
+		return nil, err
+	}
+
+	config.Producer.Return.Successes = true
+	config.Producer.Return.Errors = true
+	config.Producer.Retry.Max = defaultRetryMax
+	if c.RetryMax > 0 {
+		config.Producer.Retry.Max = c.RetryMax
+	} else if c.RetryMax < 0 {
+		config.Producer.Retry.Max = 0
+	}
+	config.Producer.Retry.Backoff = defaultRetryBackoff
+	if c.RetryBackoff > 0 {
+		config.Producer.Retry.Backoff = c.RetryBackoff
+	}
+
+	switch c.AckRule {
+	case "WaitForAll":
+		config.Producer.RequiredAcks = sarama.WaitForAll
+	case "NoResponse":
+		config.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		config.Producer.RequiredAcks = sarama.WaitForLocal
+	}
+	if c.AckTimeout > 0 {
+		config.Producer.Timeout = c.AckTimeout
+	}
+	config.Producer.Partitioner = newPartitionerConstructor(c.Partitioner)
+
+	switch c.Compression {
+	case "gzip":
+		config.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		config.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		config.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		config.Producer.Compression = sarama.CompressionZSTD
+		if !config.Version.IsAtLeast(sarama.V2_1_0_0) {
+			config.Version = sarama.V2_1_0_0
+		}
+	default:
+		config.Producer.Compression = sarama.CompressionNone
+	}
+	if c.MaxMessageBytes > 0 {
+		config.Producer.MaxMessageBytes = c.MaxMessageBytes
+	}
+	if c.FlushBytes > 0 {
+		config.Producer.Flush.Bytes = c.FlushBytes
+	}
+	if c.FlushMessages > 0 {
+		config.Producer.Flush.Messages = c.FlushMessages
+	}
+	if c.FlushFrequency > 0 {
+		config.Producer.Flush.Frequency = c.FlushFrequency
+	}
+
+	if c.Idempotent || c.TransactionalId != "" {
+		setIdempotent(config)
+	}
+	if c.TransactionalId != "" {
+		config.Producer.Transaction.ID = c.TransactionalId
+	}
+
+	return config, nil
+}
+
+// 生成消费者的sarama-cluster配置
+func (c *Config) BuildConsumerConfig() (*cluster.Config, error) {
+	config := cluster.NewConfig()
+	if err := c.applyClientConfig(&config.Config); err != nil {
+		return nil, err
+	}
+
+	config.Group.Return.Notifications = true
+	if c.CommitInterval > 0 {
+		config.Consumer.Offsets.CommitInterval = c.CommitInterval
+	}
+	if c.FromOffsets == "Newest" {
+		config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	} else {
+		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	}
+	if c.IsolationLevel == "ReadCommitted" {
+		config.Consumer.IsolationLevel = sarama.ReadCommitted
+		if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+			config.Version = sarama.V0_11_0_0
+		}
+	}
+	if c.FetchMin > 0 {
+		config.Consumer.Fetch.Min = c.FetchMin
+	}
+	if c.FetchDefault > 0 {
+		config.Consumer.Fetch.Default = c.FetchDefault
+	}
+	if c.FetchMax > 0 {
+		config.Consumer.Fetch.Max = c.FetchMax
+	}
+	if c.MaxWaitTime > 0 {
+		config.Consumer.MaxWaitTime = c.MaxWaitTime
+	}
+	if c.SessionTimeout > 0 {
+		config.Group.Session.Timeout = c.SessionTimeout
+	}
+	if c.HeartbeatInterval > 0 {
+		config.Group.Heartbeat.Interval = c.HeartbeatInterval
+	}
+
+	return config, nil
+}
@@ -0,0 +1,104 @@
+package kafka
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestValidate(t *testing.T) {
+	cfg := &Config{Url: []string{"127.0.0.1:9092"}, AckRule: "WaitForAll"}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.AckRule = "WaitForALL"
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("misspelled AckRule should be rejected")
+	}
+
+	cfg = &Config{Url: []string{"127.0.0.1:9092"}, Version: "2.x"}
+	if err := cfg.Validate(); err == nil {
+		t.Fatal("invalid Version should be rejected")
+	}
+
+	if err := (&Config{}).Validate(); err == nil {
+		t.Fatal("empty Url should be rejected")
+	}
+}
+
+func TestBuildProducerConfig(t *testing.T) {
+	cfg := &Config{
+		Url:            []string{"127.0.0.1:9092"},
+		Version:        "2.1.0",
+		Compression:    "zstd",
+		FlushFrequency: 10 * time.Millisecond,
+		RetryMax:       -1,
+		Idempotent:     true,
+	}
+	config, err := cfg.BuildProducerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Producer.Compression != sarama.CompressionZSTD {
+		t.Errorf("compression = %v", config.Producer.Compression)
+	}
+	if config.Producer.Retry.Max != 0 {
+		t.Errorf("retry max = %d", config.Producer.Retry.Max)
+	}
+	if config.Producer.RequiredAcks != sarama.WaitForAll || config.Net.MaxOpenRequests != 1 {
+		t.Error("idempotent producer should wait for all with one open request")
+	}
+	if config.Producer.Flush.Frequency != 10*time.Millisecond {
+		t.Errorf("flush frequency = %v", config.Producer.Flush.Frequency)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	iniFile := filepath.Join(dir, "kafka.ini")
+	iniData := "[kafka]\nurl = 10.0.0.1:9092,10.0.0.2:9092\ngroup_id = jvirt-jcs\nack_timeout = 3s\n\n" +
+		"[kafka.security]\nsasl_mechanism = SCRAM-SHA-512\nsasl_user = jcs\n"
+	if err := ioutil.WriteFile(iniFile, []byte(iniData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfigFromIni(iniFile, "kafka")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Url) != 2 || cfg.GroupId != "jvirt-jcs" || cfg.AckTimeout != 3*time.Second || cfg.Security.SASLUser != "jcs" {
+		t.Errorf("unexpected ini config %+v", cfg)
+	}
+
+	yamlFile := filepath.Join(dir, "kafka.yaml")
+	yamlData := "url: [10.0.0.1:9092]\ncommit_interval: 5s\nack_rule: WaitForAl\n"
+	if err := ioutil.WriteFile(yamlFile, []byte(yamlData), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadConfigFromYaml(yamlFile); err == nil {
+		t.Error("misspelled AckRule in yaml should be rejected")
+	}
+
+	os.Setenv("KAFKATEST_URL", "10.0.0.1:9092, 10.0.0.2:9092")
+	os.Setenv("KAFKATEST_FETCH_MAX", "1048576")
+	os.Setenv("KAFKATEST_SECURITY_TLS", "true")
+	defer os.Unsetenv("KAFKATEST_URL")
+	defer os.Unsetenv("KAFKATEST_FETCH_MAX")
+	defer os.Unsetenv("KAFKATEST_SECURITY_TLS")
+	cfg, err = LoadConfigFromEnv("kafkatest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Url) != 2 || cfg.Url[1] != "10.0.0.2:9092" || cfg.FetchMax != 1048576 || !cfg.Security.TLS {
+		t.Errorf("unexpected env config %+v", cfg)
+	}
+}
@@ -0,0 +1,129 @@
+package kafka
+
+import (
+	"container/list"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 从消息中获取幂等key，返回空字符串时不去重
+type DedupKeyFunc func(m *sarama.ConsumerMessage) string
+
+// 使用消息头作为幂等key，生产者需要在Message.Headers中设置
+func DedupByHeader(name string) DedupKeyFunc {
+	return func(m *sarama.ConsumerMessage) string {
+		return Header(m, name)
+	}
+}
+
+// 记录已处理的幂等key，基于mysql表的实现见kafka/dedup/mysql
+type DedupStore interface {
+	// key在有效期内是否已处理过
+	Exists(key string) (bool, error)
+	// 记录key已处理
+	Add(key string) error
+}
+
+// 消费去重，幂等key已处理过时跳过该消息，处理完成后记录key
+// 处理函数panic时不记录，消息重新投递时会再次处理；存储出错时仍然处理消息，保证至少一次
+func Dedup(store DedupStore, keyFunc DedupKeyFunc) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *sarama.ConsumerMessage) {
+			key := keyFunc(m)
+			if key == "" {
+				next(m)
+				return
+			}
+
+			exists, err := store.Exists(key)
+			if err != nil {
+				log.Errorf("Dedup store check key failed. Key: %s, Error: %#v.", key, err)
+			}
+			if exists {
+				log.Infof("Skip duplicate msg. Key: %s, Topic: %s, Partition: %v, Offset: %v.", key, m.Topic, m.Partition, m.Offset)
+				return
+			}
+
+			next(m)
+
+			if err := store.Add(key); err != nil {
+				log.Errorf("Dedup store add key failed. Key: %s, Error: %#v.", key, err)
+			}
+		}
+	}
+}
+
+type dedupEntry struct {
+	key      string
+	expireAt time.Time
+}
+
+// 基于内存的去重存储，最多保存size个key，超出时淘汰最久未使用的key，key超过ttl后失效
+// 只在单个进程内有效，rebalance后分区被其他实例消费时无法去重
+type MemoryDedupStore struct {
+	mu    sync.Mutex
+	size  int
+	ttl   time.Duration
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+func NewMemoryDedupStore(size int, ttl time.Duration) *MemoryDedupStore {
+	return &MemoryDedupStore{
+		size:  size,
+		ttl:   ttl,
+		ll:    list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (s *MemoryDedupStore) Exists(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e, ok := s.items[key]
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(e.Value.(*dedupEntry).expireAt) {
+		s.remove(e)
+		return false, nil
+	}
+	s.ll.MoveToFront(e)
+
+	return true, nil
+}
+
+func (s *MemoryDedupStore) Add(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expireAt := time.Now().Add(s.ttl)
+	if e, ok := s.items[key]; ok {
+		e.Value.(*dedupEntry).expireAt = expireAt
+		s.ll.MoveToFront(e)
+		return nil
+	}
+	s.items[key] = s.ll.PushFront(&dedupEntry{key: key, expireAt: expireAt})
+	for s.size > 0 && s.ll.Len() > s.size {
+		s.remove(s.ll.Back())
+	}
+
+	return nil
+}
+
+// 当前保存的key数量，包括已过期未淘汰的key
+func (s *MemoryDedupStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.ll.Len()
+}
+
+func (s *MemoryDedupStore) remove(e *list.Element) {
+	s.ll.Remove(e)
+	delete(s.items, e.Value.(*dedupEntry).key)
+}
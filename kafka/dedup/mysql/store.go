@@ -0,0 +1,60 @@
+package mysql
+
+import (
+	"fmt"
+	"time"
+
+	"jd.com/jvirt/jvirt-common/utils/db"
+	"jd.com/jvirt/jvirt-common/utils/kafka"
+)
+
+// 去重表结构，%s替换为表名
+const CreateTableSql = `CREATE TABLE IF NOT EXISTS %s (
+  dedup_key  VARCHAR(255) NOT NULL,
+  created_at DATETIME     NOT NULL,
+  PRIMARY KEY (dedup_key),
+  KEY idx_created_at (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+// 基于mysql表的去重存储，多个消费实例共享，需要定期调用Cleanup清理过期的key
+// 单独成包，kafka包不依赖db
+type DedupStore struct {
+	client *db.DBClient
+	table  string
+	ttl    time.Duration
+}
+
+var _ kafka.DedupStore = (*DedupStore)(nil)
+
+func NewDedupStore(client *db.DBClient, table string, ttl time.Duration) *DedupStore {
+	return &DedupStore{
+		client: client,
+		table:  table,
+		ttl:    ttl,
+	}
+}
+
+func (s *DedupStore) Exists(key string) (bool, error) {
+	var n int
+	querySql := fmt.Sprintf("SELECT COUNT(*) FROM %s WHERE dedup_key = ? AND created_at > ?", s.table)
+	if err := s.client.Get(&n, querySql, key, time.Now().Add(-s.ttl)); err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+func (s *DedupStore) Add(key string) error {
+	insertSql := fmt.Sprintf("INSERT INTO %s (dedup_key, created_at) VALUES (?, ?) "+
+		"ON DUPLICATE KEY UPDATE created_at = VALUES(created_at)", s.table)
+	_, err := s.client.Insert(insertSql, key, time.Now())
+
+	return err
+}
+
+// 删除过期的key，返回删除的行数
+func (s *DedupStore) Cleanup() (int64, error) {
+	deleteSql := fmt.Sprintf("DELETE FROM %s WHERE created_at <= ?", s.table)
+
+	return s.client.Update(deleteSql, time.Now().Add(-s.ttl))
+}
@@ -0,0 +1,42 @@
+package mysql
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"jd.com/jvirt/jvirt-common/utils/db"
+)
+
+func TestDedupStore(t *testing.T) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	store := NewDedupStore(db.NewDBClient(sqlx.NewDb(conn, "mysql")), "kafka_dedup", time.Hour)
+
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM kafka_dedup WHERE dedup_key = ? AND created_at > ?")).
+		WithArgs("order-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(0))
+	mock.ExpectPrepare(regexp.QuoteMeta("INSERT INTO kafka_dedup (dedup_key, created_at) VALUES (?, ?)")).
+		ExpectExec().WithArgs("order-1", sqlmock.AnyArg()).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM kafka_dedup")).
+		WithArgs("order-1", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(1))
+
+	if exists, err := store.Exists("order-1"); err != nil || exists {
+		t.Fatalf("exists = %v, err = %v", exists, err)
+	}
+	if err := store.Add("order-1"); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := store.Exists("order-1"); err != nil || !exists {
+		t.Fatalf("exists = %v, err = %v", exists, err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,40 @@
+package kafka
+
+import (
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestDedup(t *testing.T) {
+	store := NewMemoryDedupStore(2, time.Hour)
+	handled := 0
+	handler := Dedup(store, DedupByHeader("msg_id"))(func(m *sarama.ConsumerMessage) {
+		handled++
+	})
+
+	msg := func(id string) *sarama.ConsumerMessage {
+		m := &sarama.ConsumerMessage{Topic: "topic"}
+		if id != "" {
+			m.Headers = []*sarama.RecordHeader{{Key: []byte("msg_id"), Value: []byte(id)}}
+		}
+		return m
+	}
+	for _, id := range []string{"a", "a", "b", "", "", "c", "a"} {
+		handler(msg(id))
+	}
+	// a、b、两条无key的消息、c，a被c淘汰后再次处理
+	if handled != 6 {
+		t.Errorf("handled = %d", handled)
+	}
+	if store.Len() != 2 {
+		t.Errorf("len = %d", store.Len())
+	}
+
+	expired := NewMemoryDedupStore(0, -time.Second)
+	expired.Add("a")
+	if ok, _ := expired.Exists("a"); ok {
+		t.Error("expired key should not exist")
+	}
+}
@@ -0,0 +1,297 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	DelayTopicPrefix  = "kafka_delay_"          // 延迟topic的前缀，后面加上档位，如kafka_delay_10m
+	DefaultDelayGroup = "kafka_delay_forwarder" // 转发延迟消息的默认消费组
+
+	delayTargetHeader = "kafka_delay_target" // 到期后投递的topic
+	delayDueHeader    = "kafka_delay_due"    // 到期时间，unix毫秒
+
+	delayRetryBackoff    = time.Second      // 转发失败后的重试间隔，每次翻倍
+	delayMaxRetryBackoff = 30 * time.Second // 最大重试间隔
+)
+
+// 延迟档位，从小到大，每个档位对应一个延迟topic，需要预先创建
+// 消息先进入不超过剩余延迟的最大档位，档位时间到后再进入下一个档位或投递到目标topic
+var DelayTiers = []time.Duration{time.Minute, 10 * time.Minute, time.Hour}
+
+// 档位对应的延迟topic
+func DelayTopic(tier time.Duration) string {
+	switch {
+	case tier%time.Hour == 0:
+		return fmt.Sprintf("%s%dh", DelayTopicPrefix, tier/time.Hour)
+	case tier%time.Minute == 0:
+		return fmt.Sprintf("%s%dm", DelayTopicPrefix, tier/time.Minute)
+	default:
+		return fmt.Sprintf("%s%ds", DelayTopicPrefix, tier/time.Second)
+	}
+}
+
+// 不超过delay的最大档位，delay小于最小档位时返回最小档位
+func delayTier(delay time.Duration) time.Duration {
+	tier := DelayTiers[0]
+	for _, t := range DelayTiers {
+		if t <= delay {
+			tier = t
+		}
+	}
+
+	return tier
+}
+
+// 生成发往延迟topic的消息，原topic和到期时间放在消息头中
+func delayMessage(m *Message, dueAt time.Time) *Message {
+	headers := make(map[string]string, len(m.Headers)+2)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[delayTargetHeader] = m.Topic
+	headers[delayDueHeader] = strconv.FormatInt(dueAt.UnixNano()/int64(time.Millisecond), 10)
+
+	return &Message{
+		Topic:     DelayTopic(delayTier(time.Until(dueAt))),
+		Key:       m.Key,
+		Value:     m.Value,
+		Headers:   headers,
+		Timestamp: time.Now(), // 档位的计时起点
+	}
+}
+
+// 延迟delay后投递到topic，delay不大于0时直接发送
+// 需要运行DelayForwarder转发到期的消息，精度受最小档位影响，小于最小档位的延迟可能多等待一个最小档位
+func (ksp *KafkaSyncProducer) SendAfter(topic, key string, value interface{}, delay time.Duration) error {
+	return ksp.SendMessageAfter(&Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	}, delay)
+}
+
+// 延迟delay后投递消息，不支持指定分区和时间
+func (ksp *KafkaSyncProducer) SendMessageAfter(m *Message, delay time.Duration) error {
+	if delay <= 0 {
+		return ksp.SendMessage(m)
+	}
+
+	// 按目标topic编码，避免使用延迟topic的schema
+	if _, ok := m.Value.(sarama.Encoder); !ok && ksp.serializer != nil {
+		b, err := ksp.serializer.Serialize(m.Topic, m.Value)
+		if err != nil {
+			return err
+		}
+		mm := *m
+		mm.Value = sarama.ByteEncoder(b)
+		m = &mm
+	}
+
+	return ksp.SendMessage(delayMessage(m, time.Now().Add(delay)))
+}
+
+// 消费延迟topic，消息到期前暂停分区，到期后投递到目标topic或下一个档位
+type DelayForwarder struct {
+	group    sarama.ConsumerGroup
+	producer *KafkaSyncProducer
+	topics   []string
+	tiers    map[string]time.Duration
+	running  bool
+	mu       sync.Mutex
+	wg       sync.WaitGroup
+	ctx      context.Context
+	cancel   context.CancelFunc
+}
+
+// cfg.GroupId为空时使用DefaultDelayGroup，cfg.Topics不生效
+func NewDelayForwarder(cfg *Config, producer *KafkaSyncProducer) (*DelayForwarder, error) {
+	groupId := cfg.GroupId
+	if groupId == "" {
+		groupId = DefaultDelayGroup
+	}
+
+	ccfg, err := cfg.BuildConsumerConfig()
+	if err != nil {
+		log.Errorf("Invalid delay forwarder config. Error: %#v.", err)
+		return nil, err
+	}
+	config := &ccfg.Config
+	config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config.Consumer.Group.Session.Timeout = ccfg.Group.Session.Timeout
+	config.Consumer.Group.Heartbeat.Interval = ccfg.Group.Heartbeat.Interval
+	if !config.Version.IsAtLeast(sarama.V0_10_2_0) {
+		config.Version = sarama.V0_10_2_0
+	}
+
+	group, err := sarama.NewConsumerGroup(cfg.Url, groupId, config)
+	if err != nil {
+		log.Errorf("Invoke NewConsumerGroup failed. Error: %#v.", err)
+		return nil, err
+	}
+
+	f := &DelayForwarder{
+		group:    group,
+		producer: producer,
+		tiers:    make(map[string]time.Duration, len(DelayTiers)),
+	}
+	for _, tier := range DelayTiers {
+		topic := DelayTopic(tier)
+		f.topics = append(f.topics, topic)
+		f.tiers[topic] = tier
+	}
+	f.ctx, f.cancel = context.WithCancel(context.Background())
+
+	return f, nil
+}
+
+func (f *DelayForwarder) Start() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.running {
+		return
+	}
+	f.running = true
+
+	f.wg.Add(1)
+	go func() {
+		defer f.wg.Done()
+
+		for {
+			// rebalance后Consume返回，需要重新加入消费组
+			if err := f.group.Consume(f.ctx, f.topics, f); err != nil {
+				log.Errorf("DelayForwarder consume failed. Error: %#v.", err)
+				select {
+				case <-f.ctx.Done():
+				case <-time.After(time.Second):
+				}
+			}
+			if f.ctx.Err() != nil {
+				log.Info("DelayForwarder stop, Consume exit.")
+				return
+			}
+		}
+	}()
+}
+
+func (f *DelayForwarder) Close() error {
+	return f.Shutdown(context.Background())
+}
+
+// 停止转发，等待正在转发的消息完成，ctx到期时返回ShutdownError
+func (f *DelayForwarder) Shutdown(ctx context.Context) error {
+	f.cancel()
+	if !waitGroupWithContext(ctx, &f.wg) {
+		err := &ShutdownError{Client: "DelayForwarder", Err: ctx.Err()}
+		log.Errorf("DelayForwarder shutdown timeout. Error: %v.", err)
+		return err
+	}
+	if err := f.group.Close(); err != nil {
+		log.Errorf("Invoke ConsumerGroup Close failed. Error: %#v.", err)
+		return err
+	}
+
+	return nil
+}
+
+func (f *DelayForwarder) Setup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (f *DelayForwarder) Cleanup(sarama.ConsumerGroupSession) error {
+	return nil
+}
+
+func (f *DelayForwarder) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	tier := f.tiers[claim.Topic()]
+	for {
+		select {
+		case <-session.Context().Done():
+			return nil
+		case msg, ok := <-claim.Messages():
+			if !ok {
+				return nil
+			}
+			if err := f.forward(session, msg, tier); err != nil {
+				// 只有session结束时返回错误，未标记的消息由下一次分配到该分区的消费者处理
+				return nil
+			}
+		}
+	}
+}
+
+// 等待消息到期后转发，等待期间暂停分区，同一分区中前面的消息未到期时后面的消息不会被处理
+func (f *DelayForwarder) forward(session sarama.ConsumerGroupSession, msg *sarama.ConsumerMessage, tier time.Duration) error {
+	ctx := session.Context()
+	headers := Headers(msg)
+	target := headers[delayTargetHeader]
+	dueMs, err := strconv.ParseInt(headers[delayDueHeader], 10, 64)
+	if target == "" || err != nil {
+		log.Errorf("Invalid delay msg, skip it. Topic: %s, Partition: %v, Offset: %v.", msg.Topic, msg.Partition, msg.Offset)
+		session.MarkMessage(msg, "")
+		return nil
+	}
+	dueAt := time.Unix(0, dueMs*int64(time.Millisecond))
+
+	release := dueAt
+	if !msg.Timestamp.IsZero() && msg.Timestamp.Add(tier).Before(dueAt) {
+		release = msg.Timestamp.Add(tier)
+	}
+	if wait := time.Until(release); wait > 0 {
+		partitions := map[string][]int32{msg.Topic: {msg.Partition}}
+		f.group.Pause(partitions)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			f.group.Resume(partitions)
+			return ctx.Err()
+		case <-timer.C:
+		}
+		f.group.Resume(partitions)
+	}
+
+	delete(headers, delayTargetHeader)
+	delete(headers, delayDueHeader)
+	out := &Message{
+		Topic:   target,
+		Key:     string(msg.Key),
+		Value:   sarama.ByteEncoder(msg.Value),
+		Headers: headers,
+	}
+	if time.Until(dueAt) > 0 {
+		out = delayMessage(out, dueAt)
+	}
+
+	// 发送失败时退避重试，保证消息不丢失；session结束（rebalance或Shutdown）时停止重试，不标记该消息
+	backoff := delayRetryBackoff
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := f.producer.SendMessage(out)
+		if err == nil {
+			break
+		}
+		log.Errorf("DelayForwarder send msg failed, retry after %v. Topic: %s, Key: %s, Error: %#v.", backoff, out.Topic, out.Key, err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > delayMaxRetryBackoff {
+			backoff = delayMaxRetryBackoff
+		}
+	}
+	session.MarkMessage(msg, "")
+	log.Debugf("DelayForwarder forward msg success. From: %s, To: %s, Key: %s.", msg.Topic, out.Topic, out.Key)
+
+	return nil
+}
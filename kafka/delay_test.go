@@ -0,0 +1,162 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestDelayMessage(t *testing.T) {
+	cases := []struct {
+		delay time.Duration
+		topic string
+	}{
+		{10 * time.Second, "kafka_delay_1m"},
+		{5 * time.Minute, "kafka_delay_1m"},
+		{30 * time.Minute, "kafka_delay_10m"},
+		{3 * time.Hour, "kafka_delay_1h"},
+	}
+	for _, c := range cases {
+		m := &Message{Topic: "orders", Key: "k", Value: "v", Headers: map[string]string{"trace_id": "t"}}
+		dm := delayMessage(m, time.Now().Add(c.delay))
+		if dm.Topic != c.topic {
+			t.Errorf("delay %v: topic = %s, want %s", c.delay, dm.Topic, c.topic)
+		}
+		if dm.Headers[delayTargetHeader] != "orders" || dm.Headers["trace_id"] != "t" || dm.Headers[delayDueHeader] == "" {
+			t.Errorf("delay %v: headers = %v", c.delay, dm.Headers)
+		}
+		if len(m.Headers) != 1 {
+			t.Errorf("original headers modified: %v", m.Headers)
+		}
+	}
+}
+
+// 记录发送的消息，fail大于0时前fail次发送失败
+type fakeSyncProducer struct {
+	sarama.SyncProducer
+	mu   sync.Mutex
+	sent []*sarama.ProducerMessage
+	fail int
+}
+
+func (p *fakeSyncProducer) SendMessage(msg *sarama.ProducerMessage) (int32, int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.fail > 0 {
+		p.fail--
+		return 0, 0, errors.New("broker down")
+	}
+	p.sent = append(p.sent, msg)
+	return 0, int64(len(p.sent)), nil
+}
+
+type fakeConsumerGroup struct {
+	sarama.ConsumerGroup
+	calls []string
+}
+
+func (g *fakeConsumerGroup) Pause(partitions map[string][]int32) {
+	g.calls = append(g.calls, "pause")
+}
+
+func (g *fakeConsumerGroup) Resume(partitions map[string][]int32) {
+	g.calls = append(g.calls, "resume")
+}
+
+type fakeSession struct {
+	sarama.ConsumerGroupSession
+	ctx    context.Context
+	marked []int64
+}
+
+func (s *fakeSession) Context() context.Context {
+	return s.ctx
+}
+
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {
+	s.marked = append(s.marked, msg.Offset)
+}
+
+type fakeClaim struct {
+	sarama.ConsumerGroupClaim
+	topic    string
+	messages chan *sarama.ConsumerMessage
+}
+
+func (c *fakeClaim) Topic() string {
+	return c.topic
+}
+
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage {
+	return c.messages
+}
+
+// 延迟topic中的消息
+func delayedMsg(offset int64, timestamp, dueAt time.Time) *sarama.ConsumerMessage {
+	due := strconv.FormatInt(dueAt.UnixNano()/int64(time.Millisecond), 10)
+	return &sarama.ConsumerMessage{
+		Topic:     "kafka_delay_1m",
+		Offset:    offset,
+		Key:       []byte("k"),
+		Value:     []byte("v"),
+		Timestamp: timestamp,
+		Headers: []*sarama.RecordHeader{
+			{Key: []byte(delayTargetHeader), Value: []byte("orders")},
+			{Key: []byte(delayDueHeader), Value: []byte(due)},
+			{Key: []byte("trace_id"), Value: []byte("t")},
+		},
+	}
+}
+
+func TestDelayForwarder(t *testing.T) {
+	sp := &fakeSyncProducer{}
+	group := &fakeConsumerGroup{}
+	f := &DelayForwarder{group: group, producer: &KafkaSyncProducer{sp: sp}, tiers: map[string]time.Duration{"kafka_delay_1m": time.Minute}}
+	session := &fakeSession{ctx: context.Background()}
+	now := time.Now()
+
+	// 已到期的消息直接投递到目标topic，不暂停分区
+	claim := &fakeClaim{topic: "kafka_delay_1m", messages: make(chan *sarama.ConsumerMessage, 2)}
+	claim.messages <- delayedMsg(1, now.Add(-time.Minute), now.Add(-time.Second))
+	// 档位时间先到期：暂停分区到档位时间，再进入下一个档位
+	claim.messages <- delayedMsg(2, now.Add(-time.Minute+200*time.Millisecond), now.Add(time.Hour))
+	close(claim.messages)
+	if err := f.ConsumeClaim(session, claim); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(now); elapsed < 150*time.Millisecond {
+		t.Errorf("released after %v", elapsed)
+	}
+	if len(sp.sent) != 2 || sp.sent[0].Topic != "orders" || sp.sent[1].Topic != "kafka_delay_10m" {
+		t.Fatalf("sent = %v", sp.sent)
+	}
+	for _, h := range sp.sent[0].Headers {
+		if k := string(h.Key); k == delayTargetHeader || k == delayDueHeader {
+			t.Errorf("delay header %s not removed", k)
+		}
+	}
+	if len(session.marked) != 2 || len(group.calls) != 2 || group.calls[0] != "pause" || group.calls[1] != "resume" {
+		t.Errorf("marked = %v, calls = %v", session.marked, group.calls)
+	}
+
+	// 发送失败重试时session结束，停止重试且不标记
+	sp.fail = 100
+	ctx, cancel := context.WithCancel(context.Background())
+	session = &fakeSession{ctx: ctx}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		cancel()
+	}()
+	start := time.Now()
+	if err := f.forward(session, delayedMsg(3, now, now), time.Minute); err != context.Canceled {
+		t.Errorf("forward err = %v", err)
+	}
+	if time.Since(start) > time.Second || len(session.marked) != 0 {
+		t.Errorf("forward returned after %v, marked = %v", time.Since(start), session.marked)
+	}
+}
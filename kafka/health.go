@@ -0,0 +1,299 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+const (
+	defaultStallTimeout = 5 * time.Minute
+	errorRateWindow     = 60 // 统计最近多少秒的错误率
+)
+
+// 消费者在一个分区上的健康状态
+type PartitionHealth struct {
+	Topic       string    `json:"topic"`
+	Partition   int32     `json:"partition"`
+	LastMessage time.Time `json:"last_message"` // 最后处理完消息的时间，未处理过时为零值
+	Offset      int64     `json:"offset"`       // 最后处理完的偏移量，-1表示未处理过
+	Lag         int64     `json:"lag"`          // 未处理的消息数，未处理过消息时未知，为-1
+	Paused      bool      `json:"paused"`       // 通过Pause、PauseAll暂停，暂停的分区不检查是否卡住
+	Stalled     bool      `json:"stalled"`      // 有待处理的消息但超过StallTimeout没有处理完成
+}
+
+// 消费者的健康状态
+type HealthStatus struct {
+	Running             bool               `json:"running"` // 已开始消费且未关闭
+	Healthy             bool               `json:"healthy"`
+	Reason              string             `json:"reason,omitempty"`      // 不健康的原因
+	Error               string             `json:"error,omitempty"`       // 异常停止消费的原因，需要重新创建消费者
+	InFlightSeconds     float64            `json:"in_flight_seconds"`     // 正在处理的消息已处理的时间
+	ErrorRate           float64            `json:"error_rate"`            // 最近1分钟处理失败（panic、批量处理失败、消费错误）的比例
+	StallTimeoutSeconds float64            `json:"stall_timeout_seconds"` // 判断消费卡住的阈值
+	Partitions          []*PartitionHealth `json:"partitions"`            // 分配到的分区
+}
+
+// 提供健康状态，KafkaClusterConsumer满足该接口
+type HealthReporter interface {
+	Health() *HealthStatus
+}
+
+var _ HealthReporter = (*KafkaClusterConsumer)(nil)
+
+// 获取消费者的健康状态
+// 正在处理的消息超过StallTimeout未完成，或有分区存在待处理的消息但超过StallTimeout没有处理完成时为不健康
+func (kcc *KafkaClusterConsumer) Health() *HealthStatus {
+	kcc.mu.Lock()
+	running := kcc.running
+	stopErr := kcc.stopErr
+	kcc.mu.Unlock()
+
+	kcc.pmu.Lock()
+	paused := make(map[topicPartition]bool, len(kcc.pcs))
+	for tp := range kcc.pcs {
+		paused[tp] = kcc.pausedAll || kcc.paused[tp]
+	}
+	kcc.pmu.Unlock()
+
+	var hwms map[string]map[int32]int64
+	if kcc.consumer != nil {
+		hwms = kcc.consumer.HighWaterMarks()
+	}
+	status := kcc.health.status(time.Now(), hwms, paused)
+	status.Running = running
+	if !running {
+		status.Healthy = false
+		status.Reason = "not running"
+	}
+	if stopErr != nil {
+		status.Reason = "stopped"
+		status.Error = stopErr.Error()
+	}
+
+	return status
+}
+
+func (kcc *KafkaClusterConsumer) Healthy() bool {
+	return kcc.Health().Healthy
+}
+
+// 存活检查：消费卡住或异常停止时返回503，未开始消费或已关闭时返回200，用于判断是否需要重启进程
+func LivenessHandler(r HealthReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status := r.Health()
+		writeHealth(w, status, status.Error == "" && (!status.Running || status.Healthy))
+	})
+}
+
+// 就绪检查：正在消费且没有卡住时返回200，否则返回503
+func ReadinessHandler(r HealthReporter) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		status := r.Health()
+		writeHealth(w, status, status.Healthy)
+	})
+}
+
+func writeHealth(w http.ResponseWriter, status *HealthStatus, ok bool) {
+	w.Header().Set("Content-Type", "application/json")
+	if ok {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(status)
+}
+
+// 分区的处理进度
+type partitionProgress struct {
+	assigned    time.Time
+	lastMessage time.Time
+	consumed    int64 // 最后处理完的偏移量
+	fetched     int64 // 最后拉取到的偏移量
+}
+
+// 每秒的处理结果
+type resultBucket struct {
+	second   int64
+	messages int64
+	errors   int64
+}
+
+// 记录消费进度，所有方法在接收者为nil时不做任何事
+type consumerHealth struct {
+	mu           sync.Mutex
+	stallTimeout time.Duration
+	partitions   map[topicPartition]*partitionProgress
+	handling     time.Time // 正在处理的消息开始处理的时间，没有正在处理的消息时为零值
+	buckets      [errorRateWindow]resultBucket
+}
+
+func newConsumerHealth(stallTimeout time.Duration) *consumerHealth {
+	if stallTimeout <= 0 {
+		stallTimeout = defaultStallTimeout
+	}
+
+	return &consumerHealth{
+		stallTimeout: stallTimeout,
+		partitions:   make(map[topicPartition]*partitionProgress),
+	}
+}
+
+func (h *consumerHealth) assign(tp topicPartition) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.partitions[tp] = &partitionProgress{assigned: time.Now(), consumed: -1, fetched: -1}
+}
+
+func (h *consumerHealth) release(tp topicPartition) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.partitions, tp)
+}
+
+func (h *consumerHealth) fetched(msg *sarama.ConsumerMessage) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if p, ok := h.partitions[topicPartition{msg.Topic, msg.Partition}]; ok && msg.Offset > p.fetched {
+		p.fetched = msg.Offset
+	}
+}
+
+func (h *consumerHealth) begin(start time.Time) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handling = start
+}
+
+func (h *consumerHealth) end() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.handling = time.Time{}
+}
+
+func (h *consumerHealth) consumed(msg *sarama.ConsumerMessage) {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	if p, ok := h.partitions[topicPartition{msg.Topic, msg.Partition}]; ok {
+		p.lastMessage = now
+		if msg.Offset > p.consumed {
+			p.consumed = msg.Offset
+		}
+	}
+	h.bucket(now).messages++
+}
+
+func (h *consumerHealth) observeError() {
+	if h == nil {
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.bucket(time.Now()).errors++
+}
+
+// 当前秒的统计，调用方持有mu
+func (h *consumerHealth) bucket(now time.Time) *resultBucket {
+	sec := now.Unix()
+	b := &h.buckets[sec%errorRateWindow]
+	if b.second != sec {
+		*b = resultBucket{second: sec}
+	}
+
+	return b
+}
+
+func (h *consumerHealth) status(now time.Time, hwms map[string]map[int32]int64, paused map[topicPartition]bool) *HealthStatus {
+	status := &HealthStatus{Healthy: true, Partitions: make([]*PartitionHealth, 0)}
+	if h == nil {
+		return status
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	status.StallTimeoutSeconds = h.stallTimeout.Seconds()
+
+	if !h.handling.IsZero() {
+		inflight := now.Sub(h.handling)
+		status.InFlightSeconds = inflight.Seconds()
+		if inflight > h.stallTimeout {
+			status.Healthy = false
+			status.Reason = fmt.Sprintf("handler in flight for %v", inflight.Truncate(time.Second))
+		}
+	}
+
+	var messages, errors int64
+	for _, b := range h.buckets {
+		if now.Unix()-b.second < errorRateWindow {
+			messages += b.messages
+			errors += b.errors
+		}
+	}
+	if errors > 0 {
+		status.ErrorRate = 1
+		if messages > errors {
+			status.ErrorRate = float64(errors) / float64(messages)
+		}
+	}
+
+	for tp, p := range h.partitions {
+		ph := &PartitionHealth{
+			Topic:       tp.topic,
+			Partition:   tp.partition,
+			LastMessage: p.lastMessage,
+			Offset:      p.consumed,
+			Lag:         -1,
+			Paused:      paused[tp],
+		}
+		// 未处理过消息时无法从高水位得知是否有积压，只根据是否拉取到消息判断
+		pending := p.fetched > p.consumed
+		if hwm, ok := hwms[tp.topic][tp.partition]; ok && p.consumed >= 0 {
+			ph.Lag = hwm - p.consumed - 1
+			pending = pending || ph.Lag > 0
+		}
+		since := p.assigned
+		if p.lastMessage.After(since) {
+			since = p.lastMessage
+		}
+		if pending && !ph.Paused && now.Sub(since) > h.stallTimeout {
+			ph.Stalled = true
+			if status.Healthy {
+				status.Healthy = false
+				status.Reason = fmt.Sprintf("partition %s/%d stalled for %v", tp.topic, tp.partition, now.Sub(since).Truncate(time.Second))
+			}
+		}
+		status.Partitions = append(status.Partitions, ph)
+	}
+	sort.Slice(status.Partitions, func(i, j int) bool {
+		a, b := status.Partitions[i], status.Partitions[j]
+		if a.Topic != b.Topic {
+			return a.Topic < b.Topic
+		}
+		return a.Partition < b.Partition
+	})
+
+	return status
+}
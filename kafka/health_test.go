@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestConsumerHealthStall(t *testing.T) {
+	h := newConsumerHealth(time.Minute)
+	idle, busy := topicPartition{"orders", 0}, topicPartition{"orders", 1}
+	h.assign(idle)
+	h.assign(busy)
+	h.fetched(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 10})
+	h.consumed(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 9})
+	hwms := map[string]map[int32]int64{"orders": {0: 100, 1: 11}}
+
+	// 阈值内有积压仍健康
+	now := time.Now()
+	if s := h.status(now, hwms, nil); !s.Healthy || s.Partitions[1].Lag != 1 {
+		t.Fatalf("status = %+v", s)
+	}
+
+	// 超过阈值：未处理过消息且没有拉取到消息的分区不算卡住，有积压的分区卡住
+	later := now.Add(2 * time.Minute)
+	s := h.status(later, hwms, nil)
+	if s.Healthy || s.Partitions[0].Stalled || !s.Partitions[1].Stalled {
+		t.Fatalf("status = %+v, partitions = %+v, %+v", s, s.Partitions[0], s.Partitions[1])
+	}
+
+	// 暂停的分区不检查
+	if s := h.status(later, hwms, map[topicPartition]bool{busy: true}); !s.Healthy {
+		t.Fatalf("paused status = %+v", s)
+	}
+
+	// 处理完积压后恢复健康
+	h.consumed(&sarama.ConsumerMessage{Topic: "orders", Partition: 1, Offset: 10})
+	if s := h.status(later, hwms, nil); !s.Healthy {
+		t.Fatalf("status = %+v", s)
+	}
+
+	// 处理函数卡住
+	h.begin(now)
+	if s := h.status(later, hwms, nil); s.Healthy || s.InFlightSeconds < 120 {
+		t.Fatalf("status = %+v", s)
+	}
+	h.end()
+
+	h.observeError()
+	if s := h.status(time.Now(), hwms, nil); s.ErrorRate != 0.5 {
+		t.Errorf("error rate = %v", s.ErrorRate)
+	}
+}
+
+type staticHealth HealthStatus
+
+func (s *staticHealth) Health() *HealthStatus {
+	status := HealthStatus(*s)
+	return &status
+}
+
+func TestHealthHandlers(t *testing.T) {
+	cases := []struct {
+		status          HealthStatus
+		liveness, ready int
+	}{
+		{HealthStatus{Running: true, Healthy: true}, http.StatusOK, http.StatusOK},
+		{HealthStatus{Running: true, Healthy: false}, http.StatusServiceUnavailable, http.StatusServiceUnavailable},
+		{HealthStatus{Running: false, Healthy: false}, http.StatusOK, http.StatusServiceUnavailable},
+	}
+	for _, c := range cases {
+		r := staticHealth(c.status)
+		checks := []struct {
+			handler http.Handler
+			want    int
+		}{{LivenessHandler(&r), c.liveness}, {ReadinessHandler(&r), c.ready}}
+		for _, check := range checks {
+			rec := httptest.NewRecorder()
+			check.handler.ServeHTTP(rec, httptest.NewRequest("GET", "/healthz", nil))
+			if rec.Code != check.want {
+				t.Errorf("%+v: code = %d, want %d", c.status, rec.Code, check.want)
+			}
+		}
+	}
+}
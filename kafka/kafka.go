@@ -1,9 +1,12 @@
 package kafka
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -11,22 +14,68 @@ import (
 	"github.com/bsm/sarama-cluster"
 )
 
-type ConsumerConfig struct {
-	Name           string        //客户端名称，用于监控查看问题
-	Url            []string      //可多个，逗号分隔
-	Topics         []string      //可多个，逗号分隔
-	GroupId        string        //消费组
-	FromOffsets    string        //消费配置：偏移量，支持（Newest，Oldest）二种，默认使用Oldest
-	CommitInterval time.Duration //消费配置：多久提交一次偏移量，默认1秒一次
+// 生产者接口，KafkaSyncProducer 和 MemoryProducer 满足该接口，业务代码依赖该接口便于测试
+type Producer interface {
+	Send(topic, key string, value interface{}) error
+	SendMessage(m *Message) error
+	SendBatch(msgs []*Message) ([]*DeliveryReport, error)
+	Shutdown(ctx context.Context) error
+	Close() error
 }
 
+// 消费者接口，KafkaClusterConsumer 和 MemoryConsumer 满足该接口
+type Consumer interface {
+	Use(middlewares ...Middleware)
+	CheckConsumeResult()
+	ListenMsg(consumeFunc func(m *sarama.ConsumerMessage))
+	Shutdown(ctx context.Context) error
+	Close() error
+}
+
+var (
+	_ Producer = (*KafkaSyncProducer)(nil)
+	_ Producer = (*MemoryProducer)(nil)
+	_ Consumer = (*KafkaClusterConsumer)(nil)
+	_ Consumer = (*MemoryConsumer)(nil)
+)
+
+// KafkaClusterConsumer用到的cluster.Consumer的方法，测试时可以替换
+type clusterConsumer interface {
+	Partitions() <-chan cluster.PartitionConsumer
+	Notifications() <-chan *cluster.Notification
+	Errors() <-chan error
+	MarkOffset(msg *sarama.ConsumerMessage, metadata string)
+	HighWaterMarks() map[string]map[int32]int64
+	Close() error
+}
+
+var _ clusterConsumer = (*cluster.Consumer)(nil)
+
 type KafkaClusterConsumer struct {
-	running  bool
-	wg       sync.WaitGroup
-	mu       sync.Mutex
-	groupId  string
-	consumer *cluster.Consumer // 消费消息
-	done     chan struct{}
+	running      bool
+	wg           sync.WaitGroup
+	mu           sync.Mutex
+	groupId      string
+	consumer     clusterConsumer // 消费消息
+	done         chan struct{}
+	closeOnce    sync.Once
+	inflight     int32 // 正在处理的消息数
+	name         string
+	metrics      *Metrics
+	handlers     []Middleware
+	queue        chan *sarama.ConsumerMessage // 已拉取待处理的消息
+	fetchOnce    sync.Once
+	pmu          sync.Mutex // 保护以下暂停状态
+	pcs          map[topicPartition]cluster.PartitionConsumer
+	paused       map[topicPartition]bool
+	pausedAll    bool
+	throttled    bool // 队列满时自动暂停
+	health       *consumerHealth
+	stopErr      error // 事务不可恢复等错误导致停止消费，需要重新创建消费者
+	deserializer Deserializer
+	assigned     chan struct{} // 第一次分配到分区后关闭
+	assignOnce   sync.Once
+	claimStore   BlobStore // 不为nil时处理前读取大消息的内容
 }
 
 // 实例化消费者
@@ -36,27 +85,34 @@ func NewKafkaClusterConsumer(cfg *ConsumerConfig) (*KafkaClusterConsumer, error)
 		return nil, errors.New("group id not configured")
 	}
 
-	config := cluster.NewConfig()
-	config.ClientID = cfg.Name
-	config.Group.Return.Notifications = true
-	if cfg.CommitInterval > 0 {
-		config.Consumer.Offsets.CommitInterval = cfg.CommitInterval
-	}
-	if cfg.FromOffsets == "Newest" {
-		config.Consumer.Offsets.Initial = sarama.OffsetNewest
-	} else {
-		config.Consumer.Offsets.Initial = sarama.OffsetOldest
+	config, err := cfg.BuildConsumerConfig()
+	if err != nil {
+		log.Errorf("Invalid consumer config. Error: %#v.", err)
+		return nil, err
 	}
+	// 按分区消费，才能单独暂停和恢复分区
+	config.Group.Mode = cluster.ConsumerModePartitions
 	consumer, err := cluster.NewConsumer(cfg.Url, cfg.GroupId, cfg.Topics, config)
 	if err != nil {
 		log.Errorf("Invoke NewConsumer failed. Error: %#v.", err)
 		return nil, err
 	}
+	queueSize := cfg.QueueSize
+	if queueSize <= 0 {
+		queueSize = config.ChannelBufferSize
+	}
 
 	return &KafkaClusterConsumer{
-		groupId:  cfg.Name,
+		groupId:  cfg.GroupId,
 		consumer: consumer,
 		done:     make(chan struct{}),
+		name:     cfg.Name,
+		metrics:  cfg.Metrics,
+		queue:    make(chan *sarama.ConsumerMessage, queueSize),
+		pcs:      make(map[topicPartition]cluster.PartitionConsumer),
+		paused:   make(map[topicPartition]bool),
+		health:   newConsumerHealth(cfg.StallTimeout),
+		assigned: make(chan struct{}),
 	}, nil
 }
 
@@ -71,33 +127,119 @@ func (kcc *KafkaClusterConsumer) CheckConsumeResult() {
 			case <-kcc.done:
 				log.Info("KafkaClusterConsumer stop, CheckConsumeResult exit.")
 				return
-			case <-kcc.consumer.Notifications():
+			case n := <-kcc.consumer.Notifications():
 				log.Debug("KafkaClusterConsumer consume success.")
+				if n != nil && n.Type == cluster.RebalanceOK {
+					kcc.metrics.observeRebalance(kcc.name)
+					if len(n.Current) > 0 {
+						kcc.assignOnce.Do(func() { close(kcc.assigned) })
+					}
+				}
 			case e := <-kcc.consumer.Errors():
 				log.Errorf("KafkaClusterConsumer consume failed. Err: %#v.", e)
+				kcc.observeError()
 			}
 		}
 	}()
 }
 
 func (kcc *KafkaClusterConsumer) Close() error {
-	close(kcc.done)
-	kcc.wg.Wait()
-	err := kcc.consumer.Close()
-	kcc.running = false
+	return kcc.Shutdown(context.Background())
+}
+
+// 停止接收新消息，等待正在处理的消息完成后提交偏移量并关闭
+// ctx到期时不再等待，直接关闭消费者并返回ShutdownError
+func (kcc *KafkaClusterConsumer) Shutdown(ctx context.Context) error {
+	var err error
+	kcc.closeOnce.Do(func() {
+		close(kcc.done)
+		if !waitGroupWithContext(ctx, &kcc.wg) {
+			err = &ShutdownError{
+				Client:  "KafkaClusterConsumer",
+				Pending: int64(atomic.LoadInt32(&kcc.inflight)),
+				Err:     ctx.Err(),
+			}
+			log.Errorf("KafkaClusterConsumer shutdown timeout. Error: %v.", err)
+		}
+
+		// Close时会提交已标记的偏移量
+		if e := kcc.consumer.Close(); e != nil {
+			log.Errorf("Invoke Consumer Close failed. Error: %#v.", e)
+			if err == nil {
+				err = e
+			}
+		}
+		kcc.mu.Lock()
+		kcc.running = false
+		kcc.mu.Unlock()
+	})
 
 	return err
 }
 
-// 接收消息
+// 设置消息值的反序列化方式，如AvroSerde、ProtobufSerde，与生产者的SetSerializer对应，需要在ListenMsg之前调用
+func (kcc *KafkaClusterConsumer) SetDeserializer(d Deserializer) {
+	kcc.mu.Lock()
+	defer kcc.mu.Unlock()
+	kcc.deserializer = d
+}
+
+// 在处理函数中解码消息值，未设置反序列化方式时按json解码，与生产者的默认编码一致
+func (kcc *KafkaClusterConsumer) Decode(m *sarama.ConsumerMessage, v interface{}) error {
+	kcc.mu.Lock()
+	d := kcc.deserializer
+	kcc.mu.Unlock()
+	if d == nil {
+		return json.Unmarshal(m.Value, v)
+	}
+
+	return DecodeMessage(d, m, v)
+}
+
+// 开始关闭时关闭的channel，用于在Shutdown时中断处理函数中的等待，如Retry的重试间隔
+func (kcc *KafkaClusterConsumer) Done() <-chan struct{} {
+	return kcc.done
+}
+
+// 等待第一次分配到分区，此时各分区的起始偏移量已确定，之后写入的消息都会被消费到
+// 分配结果通过CheckConsumeResult接收，需要先调用CheckConsumeResult
+func (kcc *KafkaClusterConsumer) WaitAssigned(ctx context.Context) error {
+	select {
+	case <-kcc.assigned:
+		return nil
+	case <-kcc.done:
+		return ErrConsumerClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// 添加消息处理中间件，按添加顺序由外到内执行，需要在ListenMsg之前调用
+func (kcc *KafkaClusterConsumer) Use(middlewares ...Middleware) {
+	kcc.mu.Lock()
+	defer kcc.mu.Unlock()
+	kcc.handlers = append(kcc.handlers, middlewares...)
+}
+
+// 接收消息，consumeFunc panic时记录日志并继续消费后面的消息
 func (kcc *KafkaClusterConsumer) ListenMsg(consumeFunc func(m *sarama.ConsumerMessage)) {
 	kcc.mu.Lock()
 	defer kcc.mu.Unlock()
-	if kcc.running {
+	if kcc.running || kcc.stopErr != nil {
 		return
 	}
 	kcc.running = true
 
+	if consumeFunc == nil {
+		consumeFunc = func(m *sarama.ConsumerMessage) {}
+	}
+	middlewares := []Middleware{Recover(kcc.onPanic)}
+	if kcc.claimStore != nil {
+		middlewares = append(middlewares, ClaimCheck(kcc.claimStore, kcc.onClaimCheckError))
+	}
+	handler := Chain(consumeFunc, append(middlewares, kcc.handlers...)...)
+	kcc.startFetch()
+
 	// 监听消息
 	kcc.wg.Add(1)
 	go func() {
@@ -108,49 +250,66 @@ func (kcc *KafkaClusterConsumer) ListenMsg(consumeFunc func(m *sarama.ConsumerMe
 			case <-kcc.done:
 				log.Info("KafkaClusterConsumer stop, Listen exit.")
 				return
-			case msg := <-kcc.consumer.Messages():
-				kcc.consumer.MarkOffset(msg, "") // MarkOffset 并不是实时写入kafka，有可能在程序crash时丢掉未提交的offset
+			case msg := <-kcc.queue:
+				kcc.checkThrottle()
 				// 消费消息.
 				log.Debugf("KafkaClusterConsumer ConsumeMsg: GroupID: %s, Topic: %s, Partition: %v, Offset: %v, Timestamp: %v",
 					kcc.groupId, msg.Topic, msg.Partition, msg.Offset, msg.Timestamp)
-				if consumeFunc != nil {
-					consumeFunc(msg)
-				}
+				atomic.AddInt32(&kcc.inflight, 1)
+				start := time.Now()
+				kcc.health.begin(start)
+				handler(msg)
+				kcc.observeConsume(msg, time.Since(start))
+				kcc.health.end()
+				atomic.AddInt32(&kcc.inflight, -1)
+				kcc.consumer.MarkOffset(msg, "") // 处理完成后再标记，MarkOffset 并不是实时写入kafka，程序crash时未提交的消息会被重新消费
 			}
 		}
 	}()
 }
 
-type ProducerConfig struct {
-	Name       string        //客户端名称，用于监控查看问题
-	Url        []string      //可多个，逗号分隔
-	AckRule    string        //发送配置：ack规则（NoResponse、WaitForLocal、WaitForAll）默认为WaitForLocal
-	AckTimeout time.Duration //发送配置：等待Ack最大时间，默认10秒
+func (kcc *KafkaClusterConsumer) onPanic(m *sarama.ConsumerMessage, p interface{}) {
+	kcc.observeError()
+}
+
+func (kcc *KafkaClusterConsumer) onClaimCheckError(m *sarama.ConsumerMessage, err error) {
+	kcc.observeError()
+	skipClaimCheck(m, err)
+}
+
+func (kcc *KafkaClusterConsumer) observeError() {
+	kcc.health.observeError()
+	kcc.metrics.observeConsumeError(kcc.name)
+}
+
+// 记录处理进度并统计消费指标，未配置监控时不统计指标
+func (kcc *KafkaClusterConsumer) observeConsume(msg *sarama.ConsumerMessage, duration time.Duration) {
+	kcc.health.consumed(msg)
+	if kcc.metrics == nil {
+		return
+	}
+	kcc.metrics.observeConsume(kcc.name, msg.Topic, len(msg.Key)+len(msg.Value), duration)
+	if hwm, ok := kcc.consumer.HighWaterMarks()[msg.Topic][msg.Partition]; ok {
+		kcc.metrics.setLag(kcc.name, msg.Topic, msg.Partition, hwm-msg.Offset-1)
+	}
 }
 
 type KafkaSyncProducer struct {
-	sp sarama.SyncProducer
+	sp         sarama.SyncProducer
+	mu         sync.RWMutex // 发送时持有读锁，关闭时等待正在发送的消息
+	closed     bool
+	name       string
+	metrics    *Metrics
+	serializer Serializer
+	claimCheck *claimCheck
 }
 
 // 实例化生产者
 func NewKafkaSyncProducer(cfg *ProducerConfig) (*KafkaSyncProducer, error) {
-	config := sarama.NewConfig()
-	config.ClientID = cfg.Name
-	config.Producer.Return.Successes = true
-	config.Producer.Return.Errors = true
-	config.Producer.Retry.Max = 5
-	config.Producer.Retry.Backoff = 100 * time.Millisecond
-
-	switch cfg.AckRule {
-	case "WaitForAll":
-		config.Producer.RequiredAcks = sarama.WaitForAll
-	case "NoResponse":
-		config.Producer.RequiredAcks = sarama.NoResponse
-	default:
-		config.Producer.RequiredAcks = sarama.WaitForLocal
-	}
-	if cfg.AckTimeout > 0 {
-		config.Producer.Timeout = cfg.AckTimeout
+	config, err := cfg.BuildProducerConfig()
+	if err != nil {
+		log.Errorf("Invalid producer config. Error: %#v.", err)
+		return nil, err
 	}
 	producer, err := sarama.NewSyncProducer(cfg.Url, config)
 	if err != nil {
@@ -159,32 +318,83 @@ func NewKafkaSyncProducer(cfg *ProducerConfig) (*KafkaSyncProducer, error) {
 	}
 
 	return &KafkaSyncProducer{
-		sp: producer,
+		sp:      producer,
+		name:    cfg.Name,
+		metrics: cfg.Metrics,
 	}, nil
 }
 
+// 设置消息值的序列化方式，如AvroSerde、ProtobufSerde，需要在发送消息前调用
+func (ksp *KafkaSyncProducer) SetSerializer(s Serializer) {
+	ksp.serializer = s
+}
+
 func (ksp *KafkaSyncProducer) Close() error {
-	if err := ksp.sp.Close(); err != nil {
-		log.Errorf("Invoke SyncProducer Close failed. Error: %#v.", err)
+	return ksp.Shutdown(context.Background())
+}
+
+// 停止接收新消息，等待正在发送的消息完成后关闭，ctx到期时返回ShutdownError
+func (ksp *KafkaSyncProducer) Shutdown(ctx context.Context) error {
+	result := make(chan error, 1)
+	go func() {
+		ksp.mu.Lock()
+		if ksp.closed {
+			ksp.mu.Unlock()
+			result <- nil
+			return
+		}
+		ksp.closed = true
+		ksp.mu.Unlock()
+
+		if err := ksp.sp.Close(); err != nil {
+			log.Errorf("Invoke SyncProducer Close failed. Error: %#v.", err)
+			result <- err
+			return
+		}
+		result <- nil
+	}()
+
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		err := &ShutdownError{Client: "KafkaSyncProducer", Err: ctx.Err()}
+		log.Errorf("KafkaSyncProducer shutdown timeout. Error: %v.", err)
 		return err
 	}
-
-	return nil
 }
 
 // 同步发送，重试3次
 func (ksp *KafkaSyncProducer) Send(topic, key string, value interface{}) error {
-	v, err := json.Marshal(value)
+	return ksp.SendMessage(&Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	})
+}
+
+// 同步发送，可指定消息头、分区和时间，配置了TracerProvider时记录span并在消息头中写入traceparent
+func (ksp *KafkaSyncProducer) SendMessage(m *Message) error {
+	return ksp.SendMessageContext(context.Background(), m)
+}
+
+func (ksp *KafkaSyncProducer) sendMessage(m *Message) error {
+	ksp.mu.RLock()
+	defer ksp.mu.RUnlock()
+	if ksp.closed {
+		return ErrProducerClosed
+	}
+	// 关闭后不再保存大消息内容，避免存储中留下没有引用的内容
+	msg, err := m.encodeWith(ksp.serializer)
 	if err != nil {
-		log.Errorf("Invoke json marshal failed. Err: %#v.", err)
+		return err
 	}
-
-	msg := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.ByteEncoder(v),
+	if err := ksp.claimCheck.apply(msg); err != nil {
+		return err
 	}
+	start := time.Now()
 	p, offset, err := ksp.sp.SendMessage(msg)
+	ksp.metrics.observeProduce(ksp.name, msg.Topic, producerMessageBytes(msg), time.Since(start), err)
 	if err != nil {
 		log.Errorf("KafkaSyncProducer SendMessage failed. Error: %#v.", err)
 		return err
@@ -194,31 +404,121 @@ func (ksp *KafkaSyncProducer) Send(topic, key string, value interface{}) error {
 	return nil
 }
 
-type KafkaAsyncProducer struct {
-	asp  sarama.AsyncProducer
-	done chan struct{}
-	wg   sync.WaitGroup
-}
+// 批量同步发送，一次请求发送多条消息，返回与msgs一一对应的发送结果
+// 有消息发送失败时返回BatchError，失败的消息可以从结果中Err不为nil的项找到
+func (ksp *KafkaSyncProducer) SendBatch(msgs []*Message) ([]*DeliveryReport, error) {
+	ksp.mu.RLock()
+	defer ksp.mu.RUnlock()
+	if ksp.closed {
+		return nil, ErrProducerClosed
+	}
 
-func NewKafkaAsyncProducer(cfg *ProducerConfig) (*KafkaAsyncProducer, error) {
-	config := sarama.NewConfig()
+	reports := make([]*DeliveryReport, len(msgs))
+	batch := make([]*sarama.ProducerMessage, 0, len(msgs))
+	index := make(map[*sarama.ProducerMessage]int, len(msgs))
+	for i, m := range msgs {
+		reports[i] = &DeliveryReport{Topic: m.Topic, Key: m.Key, Partition: -1, Offset: -1}
+		msg, err := m.encodeWith(ksp.serializer)
+		if err == nil {
+			err = ksp.claimCheck.apply(msg)
+		}
+		if err != nil {
+			reports[i].Err = err
+			continue
+		}
+		index[msg] = i
+		batch = append(batch, msg)
+	}
 
-	config.ClientID = cfg.Name
-	config.Producer.Return.Successes = true
-	config.Producer.Return.Errors = true
-	config.Producer.Retry.Max = 5
-	config.Producer.Retry.Backoff = 100 * time.Millisecond
+	var errs sarama.ProducerErrors
+	if len(batch) > 0 {
+		start := time.Now()
+		err := ksp.sp.SendMessages(batch)
+		latency := time.Since(start)
+		if err != nil {
+			pe, ok := err.(sarama.ProducerErrors)
+			if !ok {
+				// 不是逐条的发送结果时认为整批失败
+				for _, msg := range batch {
+					pe = append(pe, &sarama.ProducerError{Msg: msg, Err: err})
+				}
+			}
+			errs = pe
+		}
+		for _, pe := range errs {
+			if i, ok := index[pe.Msg]; ok {
+				reports[i].Err = pe.Err
+			}
+		}
+		for _, msg := range batch {
+			r := reports[index[msg]]
+			if r.Err == nil {
+				r.Partition = msg.Partition
+				r.Offset = msg.Offset
+			}
+			ksp.metrics.observeProduce(ksp.name, msg.Topic, producerMessageBytes(msg), latency, r.Err)
+		}
+	}
 
-	switch cfg.AckRule {
-	case "WaitForAll":
-		config.Producer.RequiredAcks = sarama.WaitForAll
-	case "NoResponse":
-		config.Producer.RequiredAcks = sarama.NoResponse
-	default:
-		config.Producer.RequiredAcks = sarama.WaitForLocal
+	failed := 0
+	for _, r := range reports {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	if failed > 0 {
+		err := &BatchError{Total: len(msgs), Failed: failed}
+		log.Errorf("KafkaSyncProducer SendBatch failed. Error: %v.", err)
+		return reports, err
 	}
-	if cfg.AckTimeout > 0 {
-		config.Producer.Timeout = cfg.AckTimeout
+	log.Debugf("KafkaSyncProducer send batch success. Count: %d.", len(msgs))
+
+	return reports, nil
+}
+
+// 批量发送中有消息失败
+type BatchError struct {
+	Total  int
+	Failed int
+}
+
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("kafka: %d of %d messages failed to send", e.Failed, e.Total)
+}
+
+// 异步发送结果
+type DeliveryReport struct {
+	Topic     string
+	Key       string
+	Partition int32
+	Offset    int64
+	Err       error // 发送失败时不为nil
+}
+
+// 异步发送结果回调，在CheckProduceResult的goroutine中执行，不要在回调中阻塞
+type DeliveryCallback func(r *DeliveryReport)
+
+type KafkaAsyncProducer struct {
+	asp        sarama.AsyncProducer
+	wg         sync.WaitGroup
+	mu         sync.RWMutex // 保护closed，关闭后不再接收新消息
+	closed     bool
+	done       chan struct{}  // 关闭时close，正在等待放入发送队列的消息放弃发送
+	sending    sync.WaitGroup // 正在放入发送队列的消息
+	checking   bool
+	pending    int64 // 已发送未返回结果的消息数
+	failed     int64 // 关闭过程中发送失败的消息数
+	name       string
+	metrics    *Metrics
+	serializer Serializer
+	claimCheck *claimCheck
+}
+
+func NewKafkaAsyncProducer(cfg *ProducerConfig) (*KafkaAsyncProducer, error) {
+	config, err := cfg.BuildProducerConfig()
+	if err != nil {
+		log.Errorf("Invalid producer config. Error: %#v.", err)
+		return nil, err
 	}
 	producer, err := sarama.NewAsyncProducer(cfg.Url, config)
 	if err != nil {
@@ -227,55 +527,226 @@ func NewKafkaAsyncProducer(cfg *ProducerConfig) (*KafkaAsyncProducer, error) {
 	}
 
 	return &KafkaAsyncProducer{
-		asp:  producer,
-		done: make(chan struct{}),
+		asp:     producer,
+		done:    make(chan struct{}),
+		name:    cfg.Name,
+		metrics: cfg.Metrics,
 	}, nil
 }
 
+// 设置消息值的序列化方式，如AvroSerde、ProtobufSerde，需要在发送消息前调用
+func (kap *KafkaAsyncProducer) SetSerializer(s Serializer) {
+	kap.serializer = s
+}
+
 func (kap *KafkaAsyncProducer) Close() error {
-	close(kap.done)
-	kap.wg.Wait()
-	err := kap.asp.Close()
+	return kap.Shutdown(context.Background())
+}
 
-	return err
+// 停止接收新消息，等待已发送的消息全部返回结果后关闭，ctx到期时返回ShutdownError
+func (kap *KafkaAsyncProducer) Shutdown(ctx context.Context) error {
+	result := make(chan bool, 1)
+	go func() {
+		kap.mu.Lock()
+		if kap.closed {
+			kap.mu.Unlock()
+			result <- false
+			return
+		}
+		kap.closed = true
+		close(kap.done)
+		kap.mu.Unlock()
+
+		// 等待正在发送的消息放入队列或放弃发送，AsyncClose后不能再向Input发送
+		kap.sending.Wait()
+		// 关闭过程中需要读取发送结果，否则会阻塞
+		kap.CheckProduceResult()
+		kap.asp.AsyncClose()
+		kap.wg.Wait()
+		result <- true
+	}()
+
+	select {
+	case closed := <-result:
+		if !closed {
+			return nil
+		}
+	case <-ctx.Done():
+		err := &ShutdownError{
+			Client:  "KafkaAsyncProducer",
+			Pending: atomic.LoadInt64(&kap.pending),
+			Failed:  atomic.LoadInt64(&kap.failed),
+			Err:     ctx.Err(),
+		}
+		log.Errorf("KafkaAsyncProducer shutdown timeout. Error: %v.", err)
+		return err
+	}
+	if failed := atomic.LoadInt64(&kap.failed); failed > 0 {
+		return &ShutdownError{Client: "KafkaAsyncProducer", Failed: failed}
+	}
+
+	return nil
 }
 
+// 读取发送结果，直到生产者关闭
 func (kap *KafkaAsyncProducer) CheckProduceResult() {
-	kap.wg.Add(1)
+	kap.mu.Lock()
+	defer kap.mu.Unlock()
+	if kap.checking {
+		return
+	}
+	kap.checking = true
 
+	kap.wg.Add(1)
 	go func() {
 		defer kap.wg.Done()
 
-		for {
+		successes, errs := kap.asp.Successes(), kap.asp.Errors()
+		for successes != nil || errs != nil {
 			select {
-			case <-kap.done:
-				log.Info("KafkaAsyncProducer stop, CheckProduceResult Exit")
-				return
-			case <-kap.asp.Successes():
-				log.Debug("KafkaAsyncProducer produce msg success.")
-			case e := <-kap.asp.Errors():
+			case msg, ok := <-successes:
+				if !ok {
+					successes = nil
+					continue
+				}
+				atomic.AddInt64(&kap.pending, -1)
+				log.Debugf("KafkaAsyncProducer produce msg success. Topic: %s, Partition: %v, Offset: %v.",
+					msg.Topic, msg.Partition, msg.Offset)
+				kap.metrics.observeProduce(kap.name, msg.Topic, producerMessageBytes(msg), sentDuration(msg), nil)
+				deliver(msg, nil)
+			case e, ok := <-errs:
+				if !ok {
+					errs = nil
+					continue
+				}
+				atomic.AddInt64(&kap.pending, -1)
+				if kap.isClosed() {
+					atomic.AddInt64(&kap.failed, 1)
+				}
 				log.Errorf("KafkaAsyncProducer produce msg failed. Err: %#v.", e)
+				if e.Msg != nil {
+					kap.metrics.observeProduce(kap.name, e.Msg.Topic, producerMessageBytes(e.Msg), sentDuration(e.Msg), e.Err)
+				}
+				deliver(e.Msg, e.Err)
 			}
 		}
+		log.Info("KafkaAsyncProducer stop, CheckProduceResult Exit")
 	}()
+}
+
+func (kap *KafkaAsyncProducer) isClosed() bool {
+	kap.mu.RLock()
+	defer kap.mu.RUnlock()
 
+	return kap.closed
 }
 
 // 异步发送
 func (kap *KafkaAsyncProducer) AsyncSend(topic, key string, value interface{}) error {
-	v, err := json.Marshal(value)
+	return kap.AsyncSendWithCallback(topic, key, value, nil)
+}
+
+// 异步发送，消息发送成功或失败后调用cb，需先调用CheckProduceResult
+func (kap *KafkaAsyncProducer) AsyncSendWithCallback(topic, key string, value interface{}, cb DeliveryCallback) error {
+	return kap.AsyncSendMessage(&Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	}, cb)
+}
+
+// 异步发送，可指定消息头、分区和时间，cb可以为nil，配置了TracerProvider时同SendMessage写入traceparent
+func (kap *KafkaAsyncProducer) AsyncSendMessage(m *Message, cb DeliveryCallback) error {
+	return kap.AsyncSendMessageContext(context.Background(), m, cb)
+}
+
+func (kap *KafkaAsyncProducer) asyncSendMessage(m *Message, cb DeliveryCallback) error {
+	kap.mu.RLock()
+	if kap.closed {
+		kap.mu.RUnlock()
+		return ErrProducerClosed
+	}
+	kap.sending.Add(1)
+	kap.mu.RUnlock()
+	defer kap.sending.Done()
+
+	// 关闭后不再保存大消息内容，关闭时等待正在保存的消息
+	msg, err := m.encodeWith(kap.serializer)
 	if err != nil {
-		log.Errorf("Invoke json marshal failed. Err: %#v.", err)
+		return err
 	}
-	msg := &sarama.ProducerMessage{
-		Topic: topic,
-		Key:   sarama.StringEncoder(key),
-		Value: sarama.ByteEncoder(v),
+	if err := kap.claimCheck.apply(msg); err != nil {
+		return err
+	}
+	// 通过Metadata关联发送结果
+	msg.Metadata = &messageMeta{
+		callback: cb,
+		sentAt:   time.Now(),
 	}
 
-	// 是否设置超时.
-	kap.asp.Input() <- msg
+	// 发送队列满时阻塞，关闭时放弃发送
+	atomic.AddInt64(&kap.pending, 1)
+	select {
+	case kap.asp.Input() <- msg:
+	case <-kap.done:
+		atomic.AddInt64(&kap.pending, -1)
+		return ErrProducerClosed
+	}
 	log.Debug("KafkaAsyncProducer send msg success.")
 
 	return nil
 }
+
+// 异步发送，返回的channel中会收到一个发送结果，需先调用CheckProduceResult
+func (kap *KafkaAsyncProducer) AsyncSendWithResult(topic, key string, value interface{}) (<-chan *DeliveryReport, error) {
+	result := make(chan *DeliveryReport, 1)
+	cb := func(r *DeliveryReport) {
+		result <- r
+	}
+	if err := kap.AsyncSendWithCallback(topic, key, value, cb); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// 异步发送的消息的Metadata
+type messageMeta struct {
+	callback DeliveryCallback
+	sentAt   time.Time
+}
+
+// 从发送到返回结果的时间
+func sentDuration(msg *sarama.ProducerMessage) time.Duration {
+	meta, ok := msg.Metadata.(*messageMeta)
+	if !ok {
+		return 0
+	}
+
+	return time.Since(meta.sentAt)
+}
+
+// 根据Metadata中的回调通知发送结果
+func deliver(msg *sarama.ProducerMessage, err error) {
+	if msg == nil {
+		return
+	}
+	meta, ok := msg.Metadata.(*messageMeta)
+	if !ok || meta.callback == nil {
+		return
+	}
+	cb := meta.callback
+
+	r := &DeliveryReport{
+		Topic:     msg.Topic,
+		Partition: msg.Partition,
+		Offset:    msg.Offset,
+		Err:       err,
+	}
+	if msg.Key != nil {
+		if k, e := msg.Key.Encode(); e == nil {
+			r.Key = string(k)
+		}
+	}
+	cb(r)
+}
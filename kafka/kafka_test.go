@@ -0,0 +1,139 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestAsyncProducerShutdown(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mp := mocks.NewAsyncProducer(t, config)
+	mp.ExpectInputAndSucceed()
+	mp.ExpectInputAndFail(errors.New("broker down"))
+
+	metrics := NewMetrics("test")
+	kap := &KafkaAsyncProducer{asp: mp, done: make(chan struct{}), name: "async", metrics: metrics}
+	kap.CheckProduceResult()
+
+	ok, err := kap.AsyncSendWithResult("topic", "key-1", map[string]string{"a": "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	failed, err := kap.AsyncSendWithResult("topic", "key-2", "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r := <-ok; r.Err != nil || r.Key != "key-1" {
+		t.Errorf("unexpected report %+v", r)
+	}
+	if r := <-failed; r.Err == nil || r.Key != "key-2" {
+		t.Errorf("unexpected report %+v", r)
+	}
+
+	if err := kap.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := kap.AsyncSend("topic", "key-3", "value"); err != ErrProducerClosed {
+		t.Errorf("send after shutdown should fail, got %v", err)
+	}
+
+	if n := testutil.ToFloat64(metrics.produced.WithLabelValues("async", "topic")); n != 1 {
+		t.Errorf("produced = %v", n)
+	}
+	if n := testutil.ToFloat64(metrics.produceErrors.WithLabelValues("async", "topic")); n != 1 {
+		t.Errorf("produce errors = %v", n)
+	}
+}
+
+// 发送队列一直满的生产者
+type blockedProducer struct {
+	sarama.AsyncProducer
+	input     chan *sarama.ProducerMessage
+	successes chan *sarama.ProducerMessage
+	errors    chan *sarama.ProducerError
+}
+
+func (p *blockedProducer) Input() chan<- *sarama.ProducerMessage     { return p.input }
+func (p *blockedProducer) Successes() <-chan *sarama.ProducerMessage { return p.successes }
+func (p *blockedProducer) Errors() <-chan *sarama.ProducerError      { return p.errors }
+func (p *blockedProducer) AsyncClose() {
+	close(p.successes)
+	close(p.errors)
+}
+
+func TestAsyncProducerShutdownBlockedSend(t *testing.T) {
+	bp := &blockedProducer{
+		input:     make(chan *sarama.ProducerMessage),
+		successes: make(chan *sarama.ProducerMessage),
+		errors:    make(chan *sarama.ProducerError),
+	}
+	kap := &KafkaAsyncProducer{asp: bp, done: make(chan struct{})}
+
+	sent := make(chan error, 1)
+	go func() { sent <- kap.AsyncSend("topic", "key", "value") }()
+	for atomic.LoadInt64(&kap.pending) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// 阻塞在发送队列的消息不影响关闭，关闭后放弃发送
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := kap.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-sent; err != ErrProducerClosed {
+		t.Errorf("blocked send = %v", err)
+	}
+	if n := atomic.LoadInt64(&kap.pending); n != 0 {
+		t.Errorf("pending = %d", n)
+	}
+}
+
+func TestSyncProducerSendBatch(t *testing.T) {
+	config := sarama.NewConfig()
+	config.Producer.Return.Successes = true
+	mp := mocks.NewSyncProducer(t, config)
+	mp.ExpectSendMessageAndSucceed()
+	mp.ExpectSendMessageAndSucceed()
+	mp.ExpectSendMessageAndFail(errors.New("broker down"))
+
+	ksp := &KafkaSyncProducer{sp: mp, name: "sync"}
+	msgs := []*Message{
+		{Topic: "topic", Key: "key-1", Value: "v1"},
+		{Topic: "topic", Key: "key-2", Value: func() {}}, // json编码失败，不会发送
+		{Topic: "topic", Key: "key-3", Value: "v3"},
+	}
+	reports, err := ksp.SendBatch(msgs)
+	if be, ok := err.(*BatchError); !ok || be.Total != 3 || be.Failed != 1 {
+		t.Fatalf("unexpected error %v", err)
+	}
+	for i, failed := range []bool{false, true, false} {
+		if r := reports[i]; r.Key != msgs[i].Key || (r.Err != nil) != failed {
+			t.Errorf("unexpected report %d: %+v", i, r)
+		}
+	}
+	if reports[0].Offset < 0 || reports[2].Offset <= reports[0].Offset {
+		t.Errorf("unexpected offsets %d, %d", reports[0].Offset, reports[2].Offset)
+	}
+
+	reports, err = ksp.SendBatch(msgs[:1])
+	if err == nil || reports[0].Err == nil {
+		t.Errorf("send should fail, got %v", err)
+	}
+
+	if err := ksp.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ksp.SendBatch(msgs[:1]); err != ErrProducerClosed {
+		t.Errorf("send after close should fail, got %v", err)
+	}
+}
@@ -0,0 +1,384 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 进程内的kafka模拟，用于单元测试，不需要真实的broker
+// 支持topic、分区、消费组和偏移量提交，同一消费组的多个消费者按分区分配消息
+type MemoryBroker struct {
+	mu           sync.Mutex
+	partitions   int32
+	partitioner  string
+	topics       map[string][][]*sarama.ConsumerMessage
+	partitioners map[string]sarama.Partitioner         // 每个topic一个，轮询等有状态的分区策略需要复用
+	offsets      map[string]map[string]map[int32]int64 // group -> topic -> partition -> 下一条要消费的偏移量
+	busy         map[string]bool                       // 消费组正在处理的分区
+	members      map[string][]*MemoryConsumer
+	notify       chan struct{} // 有新消息或消费组变化时关闭并重建
+}
+
+// partitions为自动创建topic时的分区数，partitioner同Config.Partitioner
+func NewMemoryBroker(partitions int32, partitioner string) *MemoryBroker {
+	if partitions <= 0 {
+		partitions = 1
+	}
+
+	return &MemoryBroker{
+		partitions:   partitions,
+		partitioner:  partitioner,
+		topics:       make(map[string][][]*sarama.ConsumerMessage),
+		partitioners: make(map[string]sarama.Partitioner),
+		offsets:      make(map[string]map[string]map[int32]int64),
+		busy:         make(map[string]bool),
+		members:      make(map[string][]*MemoryConsumer),
+		notify:       make(chan struct{}),
+	}
+}
+
+// 创建topic，已存在时不做任何事
+func (b *MemoryBroker) CreateTopic(topic string, partitions int32) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.createTopic(topic, partitions)
+}
+
+func (b *MemoryBroker) createTopic(topic string, partitions int32) [][]*sarama.ConsumerMessage {
+	if logs, ok := b.topics[topic]; ok {
+		return logs
+	}
+	logs := make([][]*sarama.ConsumerMessage, partitions)
+	b.topics[topic] = logs
+	b.partitioners[topic] = newPartitionerConstructor(b.partitioner)(topic)
+
+	return logs
+}
+
+// 写入一条消息，topic不存在时自动创建
+func (b *MemoryBroker) Produce(m *Message) (int32, int64, error) {
+	msg, err := m.encode()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	logs := b.createTopic(msg.Topic, b.partitions)
+	partition, err := b.partitioners[msg.Topic].Partition(msg, int32(len(logs)))
+	if err != nil {
+		return 0, 0, err
+	}
+	if partition < 0 || int(partition) >= len(logs) {
+		return 0, 0, sarama.ErrInvalidPartition
+	}
+
+	cm := &sarama.ConsumerMessage{
+		Topic:     msg.Topic,
+		Partition: partition,
+		Offset:    int64(len(logs[partition])),
+		Timestamp: msg.Timestamp,
+	}
+	if cm.Timestamp.IsZero() {
+		cm.Timestamp = time.Now()
+	}
+	if msg.Key != nil {
+		if cm.Key, err = msg.Key.Encode(); err != nil {
+			return 0, 0, err
+		}
+	}
+	if cm.Value, err = msg.Value.Encode(); err != nil {
+		return 0, 0, err
+	}
+	for i := range msg.Headers {
+		cm.Headers = append(cm.Headers, &msg.Headers[i])
+	}
+	logs[partition] = append(logs[partition], cm)
+	b.broadcast()
+
+	return cm.Partition, cm.Offset, nil
+}
+
+// topic中的所有消息，按分区和偏移量排序，用于测试断言
+func (b *MemoryBroker) Messages(topic string) []*sarama.ConsumerMessage {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	msgs := make([]*sarama.ConsumerMessage, 0)
+	for _, pl := range b.topics[topic] {
+		msgs = append(msgs, pl...)
+	}
+
+	return msgs
+}
+
+// 消费组在分区上已提交的偏移量，即下一条要消费的消息，未提交过时返回-1
+func (b *MemoryBroker) Committed(groupId, topic string, partition int32) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if offset, ok := b.offsets[groupId][topic][partition]; ok {
+		return offset
+	}
+
+	return -1
+}
+
+// 消费组未消费的消息数
+func (b *MemoryBroker) Lag(groupId, topic string) int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var lag int64
+	for partition, pl := range b.topics[topic] {
+		lag += int64(len(pl)) - b.offsets[groupId][topic][int32(partition)]
+	}
+
+	return lag
+}
+
+func (b *MemoryBroker) broadcast() {
+	close(b.notify)
+	b.notify = make(chan struct{})
+}
+
+// 取出分配给消费者的下一条消息并标记分区为处理中，没有消息时返回nil
+// 消费组的分区按顺序轮流分配给组内的消费者
+func (b *MemoryBroker) fetch(c *MemoryConsumer) (*sarama.ConsumerMessage, <-chan struct{}) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	members := b.members[c.groupId]
+	index := -1
+	for i, m := range members {
+		if m == c {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, b.notify
+	}
+
+	n := 0
+	for _, topic := range c.topics {
+		logs := b.createTopic(topic, b.partitions)
+		for partition := range logs {
+			n++
+			if (n-1)%len(members) != index {
+				continue
+			}
+			busyKey := fmt.Sprintf("%s/%s/%d", c.groupId, topic, partition)
+			if b.busy[busyKey] {
+				continue
+			}
+			offset := b.offsets[c.groupId][topic][int32(partition)]
+			if offset >= int64(len(logs[partition])) {
+				continue
+			}
+			b.busy[busyKey] = true
+			return logs[partition][offset], b.notify
+		}
+	}
+
+	return nil, b.notify
+}
+
+// 提交偏移量并释放分区
+func (b *MemoryBroker) commit(groupId string, msg *sarama.ConsumerMessage) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.offsets[groupId] == nil {
+		b.offsets[groupId] = make(map[string]map[int32]int64)
+	}
+	if b.offsets[groupId][msg.Topic] == nil {
+		b.offsets[groupId][msg.Topic] = make(map[int32]int64)
+	}
+	b.offsets[groupId][msg.Topic][msg.Partition] = msg.Offset + 1
+	delete(b.busy, fmt.Sprintf("%s/%s/%d", groupId, msg.Topic, msg.Partition))
+	b.broadcast()
+}
+
+func (b *MemoryBroker) join(c *MemoryConsumer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.members[c.groupId] = append(b.members[c.groupId], c)
+	b.broadcast()
+}
+
+func (b *MemoryBroker) leave(c *MemoryConsumer) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	members := b.members[c.groupId]
+	for i, m := range members {
+		if m == c {
+			b.members[c.groupId] = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+	b.broadcast()
+}
+
+// 基于MemoryBroker的生产者，满足Producer接口
+type MemoryProducer struct {
+	broker *MemoryBroker
+	mu     sync.RWMutex
+	closed bool
+}
+
+func (b *MemoryBroker) NewProducer() *MemoryProducer {
+	return &MemoryProducer{broker: b}
+}
+
+func (mp *MemoryProducer) Send(topic, key string, value interface{}) error {
+	return mp.SendMessage(&Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	})
+}
+
+func (mp *MemoryProducer) SendMessage(m *Message) error {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	if mp.closed {
+		return ErrProducerClosed
+	}
+	_, _, err := mp.broker.Produce(m)
+
+	return err
+}
+
+func (mp *MemoryProducer) SendBatch(msgs []*Message) ([]*DeliveryReport, error) {
+	mp.mu.RLock()
+	defer mp.mu.RUnlock()
+	if mp.closed {
+		return nil, ErrProducerClosed
+	}
+
+	failed := 0
+	reports := make([]*DeliveryReport, len(msgs))
+	for i, m := range msgs {
+		r := &DeliveryReport{Topic: m.Topic, Key: m.Key, Partition: -1, Offset: -1}
+		if r.Partition, r.Offset, r.Err = mp.broker.Produce(m); r.Err != nil {
+			failed++
+		}
+		reports[i] = r
+	}
+	if failed > 0 {
+		return reports, &BatchError{Total: len(msgs), Failed: failed}
+	}
+
+	return reports, nil
+}
+
+func (mp *MemoryProducer) Shutdown(ctx context.Context) error {
+	mp.mu.Lock()
+	defer mp.mu.Unlock()
+	mp.closed = true
+
+	return nil
+}
+
+func (mp *MemoryProducer) Close() error {
+	return mp.Shutdown(context.Background())
+}
+
+// 基于MemoryBroker的消费者，满足Consumer接口，从未提交过偏移量的分区的最早消息开始消费
+type MemoryConsumer struct {
+	broker    *MemoryBroker
+	groupId   string
+	topics    []string
+	running   bool
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	done      chan struct{}
+	closeOnce sync.Once
+	handlers  []Middleware
+}
+
+func (b *MemoryBroker) NewConsumer(groupId string, topics ...string) *MemoryConsumer {
+	return &MemoryConsumer{
+		broker:  b,
+		groupId: groupId,
+		topics:  topics,
+		done:    make(chan struct{}),
+	}
+}
+
+func (mc *MemoryConsumer) Use(middlewares ...Middleware) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	mc.handlers = append(mc.handlers, middlewares...)
+}
+
+// 没有错误需要处理，为与KafkaClusterConsumer一致保留
+func (mc *MemoryConsumer) CheckConsumeResult() {
+}
+
+func (mc *MemoryConsumer) ListenMsg(consumeFunc func(m *sarama.ConsumerMessage)) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	if mc.running {
+		return
+	}
+	mc.running = true
+
+	if consumeFunc == nil {
+		consumeFunc = func(m *sarama.ConsumerMessage) {}
+	}
+	handler := Chain(consumeFunc, append([]Middleware{Recover(nil)}, mc.handlers...)...)
+	mc.broker.join(mc)
+
+	mc.wg.Add(1)
+	go func() {
+		defer mc.wg.Done()
+		defer mc.broker.leave(mc)
+
+		for {
+			msg, notify := mc.broker.fetch(mc)
+			if msg == nil {
+				select {
+				case <-mc.done:
+					log.Info("MemoryConsumer stop, Listen exit.")
+					return
+				case <-notify:
+				}
+				continue
+			}
+			handler(msg)
+			mc.broker.commit(mc.groupId, msg)
+
+			select {
+			case <-mc.done:
+				log.Info("MemoryConsumer stop, Listen exit.")
+				return
+			default:
+			}
+		}
+	}()
+}
+
+// 停止消费，等待正在处理的消息完成，ctx到期时返回ShutdownError
+func (mc *MemoryConsumer) Shutdown(ctx context.Context) error {
+	mc.closeOnce.Do(func() {
+		close(mc.done)
+	})
+	if !waitGroupWithContext(ctx, &mc.wg) {
+		return &ShutdownError{Client: "MemoryConsumer", Err: ctx.Err()}
+	}
+
+	return nil
+}
+
+func (mc *MemoryConsumer) Close() error {
+	return mc.Shutdown(context.Background())
+}
@@ -0,0 +1,77 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMemoryBroker(t *testing.T) {
+	broker := NewMemoryBroker(4, PartitionerHash)
+	var producer Producer = broker.NewProducer()
+	for i := 0; i < 20; i++ {
+		if err := producer.Send("orders", fmt.Sprintf("key-%d", i%5), i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var mu sync.Mutex
+	received := make(map[string][]string)
+	done := make(chan struct{})
+	handler := func(m *sarama.ConsumerMessage) {
+		mu.Lock()
+		defer mu.Unlock()
+		received[string(m.Key)] = append(received[string(m.Key)], string(m.Value))
+		if n := len(received["key-0"]) + len(received["key-1"]) + len(received["key-2"]) +
+			len(received["key-3"]) + len(received["key-4"]); n == 20 {
+			close(done)
+		}
+	}
+
+	consumers := []Consumer{broker.NewConsumer("g", "orders"), broker.NewConsumer("g", "orders")}
+	for _, c := range consumers {
+		c.ListenMsg(handler)
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	for _, c := range consumers {
+		if err := c.Shutdown(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 同一个key的消息在同一个分区，按发送顺序消费
+	for i := 0; i < 5; i++ {
+		want := fmt.Sprint([]string{fmt.Sprint(i), fmt.Sprint(i + 5), fmt.Sprint(i + 10), fmt.Sprint(i + 15)})
+		if got := fmt.Sprint(received[fmt.Sprintf("key-%d", i)]); got != want {
+			t.Errorf("key-%d: got %s, want %s", i, got, want)
+		}
+	}
+	if lag := broker.Lag("g", "orders"); lag != 0 {
+		t.Errorf("lag = %d", lag)
+	}
+	if lag := broker.Lag("other", "orders"); lag != 20 {
+		t.Errorf("lag of new group = %d", lag)
+	}
+}
+
+func TestMemoryBrokerRoundRobin(t *testing.T) {
+	broker := NewMemoryBroker(4, PartitionerRoundRobin)
+	for i := 0; i < 8; i++ {
+		partition, _, err := broker.Produce(&Message{Topic: "orders", Key: "k", Value: i})
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 同一个topic复用分区器，依次写入各个分区
+		if partition != int32(i%4) {
+			t.Fatalf("message %d in partition %d", i, partition)
+		}
+	}
+}
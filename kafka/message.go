@@ -0,0 +1,90 @@
+package kafka
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 待发送的消息
+type Message struct {
+	Topic     string
+	Key       string
+	Value     interface{}       // 为sarama.Encoder时直接发送，否则使用生产者的Serializer编码，未设置时使用json编码
+	Headers   map[string]string // 消息头，如trace id、content type、schema版本，需要kafka 0.11及以上版本
+	Partition int32             // 指定分区，仅在Partitioner为Manual时生效
+	Timestamp time.Time         // 消息时间，为空时使用发送时间
+}
+
+// 转换为sarama的消息
+func (m *Message) encode() (*sarama.ProducerMessage, error) {
+	return m.encodeWith(nil)
+}
+
+// 转换为sarama的消息，s不为nil时使用s编码非sarama.Encoder类型的值
+func (m *Message) encodeWith(s Serializer) (*sarama.ProducerMessage, error) {
+	var value sarama.Encoder
+	switch v := m.Value.(type) {
+	case sarama.Encoder:
+		value = v
+	default:
+		if s != nil {
+			b, err := s.Serialize(m.Topic, v)
+			if err != nil {
+				log.Errorf("Invoke Serialize failed. Topic: %s, Err: %v.", m.Topic, err)
+				return nil, err
+			}
+			value = sarama.ByteEncoder(b)
+			break
+		}
+		b, err := json.Marshal(v)
+		if err != nil {
+			log.Errorf("Invoke json marshal failed. Err: %#v.", err)
+			return nil, err
+		}
+		value = sarama.ByteEncoder(b)
+	}
+
+	msg := &sarama.ProducerMessage{
+		Topic:     m.Topic,
+		Value:     value,
+		Partition: m.Partition,
+		Timestamp: m.Timestamp,
+	}
+	// key为空时不设置，broker收到null key，分区策略按无key处理而不是全部哈希到同一个分区
+	if m.Key != "" {
+		msg.Key = sarama.StringEncoder(m.Key)
+	}
+	for k, v := range m.Headers {
+		msg.Headers = append(msg.Headers, sarama.RecordHeader{Key: []byte(k), Value: []byte(v)})
+	}
+
+	return msg, nil
+}
+
+// 获取消费到的消息的所有消息头，同名消息头取最后一个
+func Headers(msg *sarama.ConsumerMessage) map[string]string {
+	headers := make(map[string]string, len(msg.Headers))
+	for _, h := range msg.Headers {
+		if h == nil {
+			continue
+		}
+		headers[string(h.Key)] = string(h.Value)
+	}
+
+	return headers
+}
+
+// 获取消费到的消息的指定消息头，不存在时返回空字符串
+func Header(msg *sarama.ConsumerMessage, key string) string {
+	value := ""
+	for _, h := range msg.Headers {
+		if h != nil && string(h.Key) == key {
+			value = string(h.Value)
+		}
+	}
+
+	return value
+}
@@ -0,0 +1,171 @@
+package kafka
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// kafka客户端的监控指标，按客户端名称（Config.Name）区分
+// 实现了prometheus.Collector，由调用方注册到自己的Registry，通过Config.Metrics传给客户端
+type Metrics struct {
+	produced        *prometheus.CounterVec
+	producedBytes   *prometheus.CounterVec
+	produceErrors   *prometheus.CounterVec
+	produceLatency  *prometheus.HistogramVec
+	consumed        *prometheus.CounterVec
+	consumedBytes   *prometheus.CounterVec
+	consumeErrors   *prometheus.CounterVec
+	consumerLag     *prometheus.GaugeVec
+	rebalances      *prometheus.CounterVec
+	handlerDuration *prometheus.HistogramVec
+}
+
+func NewMetrics(namespace string) *Metrics {
+	return &Metrics{
+		produced: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "produced_messages_total",
+			Help:      "Number of messages successfully produced.",
+		}, []string{"client", "topic"}),
+		producedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "produced_bytes_total",
+			Help:      "Bytes of key and value successfully produced.",
+		}, []string{"client", "topic"}),
+		produceErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "produce_errors_total",
+			Help:      "Number of messages failed to produce.",
+		}, []string{"client", "topic"}),
+		produceLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "produce_latency_seconds",
+			Help:      "Time from send to broker acknowledgement.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"client", "topic"}),
+		consumed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "consumed_messages_total",
+			Help:      "Number of messages consumed.",
+		}, []string{"client", "topic"}),
+		consumedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "consumed_bytes_total",
+			Help:      "Bytes of key and value consumed.",
+		}, []string{"client", "topic"}),
+		consumeErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "consume_errors_total",
+			Help:      "Number of consumer errors.",
+		}, []string{"client"}),
+		consumerLag: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "consumer_lag",
+			Help:      "High watermark minus the next offset to consume.",
+		}, []string{"client", "topic", "partition"}),
+		rebalances: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "consumer_rebalances_total",
+			Help:      "Number of consumer group rebalances.",
+		}, []string{"client"}),
+		handlerDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "kafka",
+			Name:      "handler_duration_seconds",
+			Help:      "Time spent in the message handler.",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 2, 14),
+		}, []string{"client", "topic"}),
+	}
+}
+
+func (m *Metrics) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		m.produced, m.producedBytes, m.produceErrors, m.produceLatency,
+		m.consumed, m.consumedBytes, m.consumeErrors, m.consumerLag, m.rebalances, m.handlerDuration,
+	}
+}
+
+func (m *Metrics) Describe(ch chan<- *prometheus.Desc) {
+	for _, c := range m.collectors() {
+		c.Describe(ch)
+	}
+}
+
+func (m *Metrics) Collect(ch chan<- prometheus.Metric) {
+	for _, c := range m.collectors() {
+		c.Collect(ch)
+	}
+}
+
+// 以下方法在m为nil时不做任何事，客户端未配置监控时直接调用
+
+func (m *Metrics) observeProduce(client, topic string, bytes int, latency time.Duration, err error) {
+	if m == nil {
+		return
+	}
+	if err != nil {
+		m.produceErrors.WithLabelValues(client, topic).Inc()
+		return
+	}
+	m.produced.WithLabelValues(client, topic).Inc()
+	m.producedBytes.WithLabelValues(client, topic).Add(float64(bytes))
+	m.produceLatency.WithLabelValues(client, topic).Observe(latency.Seconds())
+}
+
+func (m *Metrics) observeConsume(client, topic string, bytes int, duration time.Duration) {
+	if m == nil {
+		return
+	}
+	m.consumed.WithLabelValues(client, topic).Inc()
+	m.consumedBytes.WithLabelValues(client, topic).Add(float64(bytes))
+	m.handlerDuration.WithLabelValues(client, topic).Observe(duration.Seconds())
+}
+
+func (m *Metrics) observeConsumeError(client string) {
+	if m == nil {
+		return
+	}
+	m.consumeErrors.WithLabelValues(client).Inc()
+}
+
+func (m *Metrics) setLag(client, topic string, partition int32, lag int64) {
+	if m == nil {
+		return
+	}
+	if lag < 0 {
+		lag = 0
+	}
+	m.consumerLag.WithLabelValues(client, topic, strconv.Itoa(int(partition))).Set(float64(lag))
+}
+
+func (m *Metrics) observeRebalance(client string) {
+	if m == nil {
+		return
+	}
+	m.rebalances.WithLabelValues(client).Inc()
+}
+
+// 消息key和value的字节数
+func producerMessageBytes(msg *sarama.ProducerMessage) int {
+	n := 0
+	if msg.Key != nil {
+		n += msg.Key.Length()
+	}
+	if msg.Value != nil {
+		n += msg.Value.Length()
+	}
+
+	return n
+}
@@ -0,0 +1,145 @@
+package kafka
+
+import (
+	"runtime/debug"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 消息处理函数，ListenMsg的参数
+type HandlerFunc func(m *sarama.ConsumerMessage)
+
+// 包装消息处理函数，在处理前后增加逻辑
+type Middleware func(next HandlerFunc) HandlerFunc
+
+// 将中间件按顺序由外到内包装到h上，Chain(h, a, b)(m)的执行顺序为a、b、h
+func Chain(h HandlerFunc, middlewares ...Middleware) HandlerFunc {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		h = middlewares[i](h)
+	}
+
+	return h
+}
+
+// 捕获处理函数的panic并记录日志和堆栈，onPanic不为nil时回调，消息视为已处理
+func Recover(onPanic func(m *sarama.ConsumerMessage, p interface{})) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *sarama.ConsumerMessage) {
+			defer func() {
+				if p := recover(); p != nil {
+					log.Errorf("Consume msg panic. Topic: %s, Partition: %v, Offset: %v, Panic: %v.\n%s",
+						m.Topic, m.Partition, m.Offset, p, debug.Stack())
+					if onPanic != nil {
+						onPanic(m, p)
+					}
+				}
+			}()
+			next(m)
+		}
+	}
+}
+
+// 记录消息的接收和处理耗时，消息头中有W3C traceparent时将其中的trace id加到日志中
+// traceHeader不为空时改为使用该消息头的值，兼容自定义trace id的生产者
+func Logging(traceHeader string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *sarama.ConsumerMessage) {
+			fields := log.Fields{
+				"topic":     m.Topic,
+				"partition": m.Partition,
+				"offset":    m.Offset,
+				"key":       string(m.Key),
+			}
+			if traceId := messageTraceId(m, traceHeader); traceId != "" {
+				fields["trace_id"] = traceId
+			}
+			entry := log.WithFields(fields)
+			entry.Debug("Consume msg start.")
+			start := time.Now()
+			next(m)
+			entry.WithField("duration", time.Since(start)).Debug("Consume msg finish.")
+		}
+	}
+}
+
+// 统计处理耗时，处理完成后调用observe，可用于上报自定义监控
+func Timing(observe func(m *sarama.ConsumerMessage, duration time.Duration)) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *sarama.ConsumerMessage) {
+			start := time.Now()
+			next(m)
+			observe(m, time.Since(start))
+		}
+	}
+}
+
+// 只处理accept返回true的消息，其他消息直接跳过
+func Filter(accept func(m *sarama.ConsumerMessage) bool) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *sarama.ConsumerMessage) {
+			if !accept(m) {
+				log.Debugf("Skip filtered msg. Topic: %s, Partition: %v, Offset: %v.", m.Topic, m.Partition, m.Offset)
+				return
+			}
+			next(m)
+		}
+	}
+}
+
+// 只处理key在keys中的消息
+func FilterByKey(keys ...string) Middleware {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[key] = true
+	}
+
+	return Filter(func(m *sarama.ConsumerMessage) bool {
+		return set[string(m.Key)]
+	})
+}
+
+// 只处理消息头name的值在values中的消息
+func FilterByHeader(name string, values ...string) Middleware {
+	set := make(map[string]bool, len(values))
+	for _, value := range values {
+		set[value] = true
+	}
+
+	return Filter(func(m *sarama.ConsumerMessage) bool {
+		return set[Header(m, name)]
+	})
+}
+
+// 将返回错误的处理函数转换为HandlerFunc，出错时最多重试attempts次，每次间隔翻倍
+// 重试用尽或done关闭时记录日志并跳过该消息，需要保留失败消息时在fn中自行处理
+// done可以使用KafkaClusterConsumer.Done()或ctx.Done()，关闭消费者时不再等待重试，为nil时一直重试到attempts次
+func Retry(done <-chan struct{}, attempts int, backoff time.Duration, fn func(m *sarama.ConsumerMessage) error) HandlerFunc {
+	return func(m *sarama.ConsumerMessage) {
+		wait := backoff
+		for i := 0; ; i++ {
+			err := fn(m)
+			if err == nil {
+				return
+			}
+			if i >= attempts {
+				log.Errorf("Consume msg failed after %d retries. Topic: %s, Partition: %v, Offset: %v, Error: %#v.",
+					attempts, m.Topic, m.Partition, m.Offset, err)
+				return
+			}
+			log.Warnf("Consume msg failed, retry after %v. Topic: %s, Partition: %v, Offset: %v, Error: %#v.",
+				wait, m.Topic, m.Partition, m.Offset, err)
+			timer := time.NewTimer(wait)
+			select {
+			case <-done:
+				timer.Stop()
+				log.Errorf("Consume msg retry canceled. Topic: %s, Partition: %v, Offset: %v, Error: %#v.",
+					m.Topic, m.Partition, m.Offset, err)
+				return
+			case <-timer.C:
+			}
+			wait *= 2
+		}
+	}
+}
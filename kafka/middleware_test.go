@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	trace := func(name string) Middleware {
+		return func(next HandlerFunc) HandlerFunc {
+			return func(m *sarama.ConsumerMessage) {
+				calls = append(calls, name)
+				next(m)
+			}
+		}
+	}
+
+	failures := 0
+	h := Chain(Retry(nil, 2, 0, func(m *sarama.ConsumerMessage) error {
+		calls = append(calls, "handler")
+		if failures < 1 {
+			failures++
+			return errors.New("failed")
+		}
+		return nil
+	}), trace("a"), FilterByKey("k1"), trace("b"))
+
+	h(&sarama.ConsumerMessage{Key: []byte("k1")})
+	h(&sarama.ConsumerMessage{Key: []byte("k2")})
+
+	want := []string{"a", "b", "handler", "handler", "a"}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v", calls)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("calls = %v", calls)
+		}
+	}
+
+	// done关闭后不再等待重试
+	done := make(chan struct{})
+	close(done)
+	attempts := 0
+	start := time.Now()
+	Retry(done, 3, time.Hour, func(m *sarama.ConsumerMessage) error {
+		attempts++
+		return errors.New("failed")
+	})(&sarama.ConsumerMessage{})
+	if attempts != 1 || time.Since(start) > time.Second {
+		t.Errorf("attempts = %d, elapsed %v", attempts, time.Since(start))
+	}
+
+	panics := 0
+	Chain(func(m *sarama.ConsumerMessage) {
+		panic("boom")
+	}, Recover(func(m *sarama.ConsumerMessage, p interface{}) {
+		panics++
+	}))(&sarama.ConsumerMessage{})
+	if panics != 1 {
+		t.Errorf("panics = %d", panics)
+	}
+}
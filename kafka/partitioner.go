@@ -0,0 +1,94 @@
+package kafka
+
+import (
+	"sync/atomic"
+
+	"github.com/Shopify/sarama"
+)
+
+// 分区策略
+const (
+	PartitionerHash       = "Hash"       // 按key的FNV-1a哈希分区，key为空时随机，默认策略
+	PartitionerMurmur2    = "Murmur2"    // 与Java客户端默认分区策略一致，key为空时轮询
+	PartitionerRoundRobin = "RoundRobin" // 轮询
+	PartitionerManual     = "Manual"     // 使用Message.Partition指定的分区
+)
+
+func newPartitionerConstructor(name string) sarama.PartitionerConstructor {
+	switch name {
+	case PartitionerMurmur2:
+		return newMurmur2Partitioner
+	case PartitionerRoundRobin:
+		return sarama.NewRoundRobinPartitioner
+	case PartitionerManual:
+		return sarama.NewManualPartitioner
+	default:
+		return sarama.NewHashPartitioner
+	}
+}
+
+// 与Java客户端DefaultPartitioner相同的分区算法，相同key在Go和Java生产者中写入同一个分区
+type murmur2Partitioner struct {
+	counter uint32
+}
+
+func newMurmur2Partitioner(topic string) sarama.Partitioner {
+	return &murmur2Partitioner{}
+}
+
+func (p *murmur2Partitioner) Partition(msg *sarama.ProducerMessage, numPartitions int32) (int32, error) {
+	if msg.Key == nil {
+		return int32(atomic.AddUint32(&p.counter, 1) % uint32(numPartitions)), nil
+	}
+	key, err := msg.Key.Encode()
+	if err != nil {
+		return -1, err
+	}
+
+	return (murmur2(key) & 0x7fffffff) % numPartitions, nil
+}
+
+func (p *murmur2Partitioner) RequiresConsistency() bool {
+	return true
+}
+
+// Java客户端org.apache.kafka.common.utils.Utils.murmur2的实现
+func murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+
+	length := len(data)
+	h := seed ^ uint32(length)
+	length4 := length / 4
+	for i := 0; i < length4; i++ {
+		i4 := i * 4
+		k := uint32(data[i4]) | uint32(data[i4+1])<<8 | uint32(data[i4+2])<<16 | uint32(data[i4+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+
+	return int32(h)
+}
@@ -0,0 +1,67 @@
+package kafka
+
+import (
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestMurmur2(t *testing.T) {
+	// 与Java客户端UtilsTest.testMurmur2的用例一致
+	cases := map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	}
+	for k, want := range cases {
+		if got := murmur2([]byte(k)); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", k, got, want)
+		}
+	}
+}
+
+func TestMurmur2Partitioner(t *testing.T) {
+	p := newMurmur2Partitioner("test")
+	msg := &sarama.ProducerMessage{Key: sarama.StringEncoder("i-rg6ut8hrg6")}
+	first, err := p.Partition(msg, 12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		partition, err := p.Partition(msg, 12)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if partition != first || partition < 0 || partition >= 12 {
+			t.Fatalf("unexpected partition %d, first %d", partition, first)
+		}
+	}
+}
+
+func TestKeylessMessageSpread(t *testing.T) {
+	for _, name := range []string{PartitionerHash, PartitionerMurmur2} {
+		p := newPartitionerConstructor(name)("test")
+		seen := make(map[int32]bool)
+		for i := 0; i < 100; i++ {
+			msg, err := (&Message{Topic: "test", Value: i}).encode()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if msg.Key != nil {
+				t.Fatalf("keyless message key = %v", msg.Key)
+			}
+			partition, err := p.Partition(msg, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			seen[partition] = true
+		}
+		// 没有key的消息不应全部写入同一个分区
+		if len(seen) < 2 {
+			t.Errorf("%s: keyless messages all in partitions %v", name, seen)
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 分区没有新消息超过该时间且高水位已到达结束位置时认为回放完成，避免结束位置是事务控制消息时一直等待
+const replayIdleTimeout = 5 * time.Second
+
+// 一个分区的回放范围，[Start, End)
+type ReplayRange struct {
+	Partition int32
+	Start     int64
+	End       int64
+	Resumed   bool // 从消费组已提交的位置继续
+}
+
+// 回放结果
+type ReplayResult struct {
+	Ranges   []*ReplayRange
+	Messages map[int32]int64 // 每个分区处理的消息数
+}
+
+// 按时间范围回放topic中的消息，用于故障恢复时重新处理
+// 默认不加入消费组、不提交偏移量；配置GroupId时使用该消费组记录回放进度，中断后再次回放从已提交的位置继续
+// GroupId需要使用专用的消费组，不能和线上消费者共用
+type Replayer struct {
+	client  sarama.Client
+	groupId string
+}
+
+func NewReplayer(cfg *Config) (*Replayer, error) {
+	config, err := cfg.BuildClientConfig()
+	if err != nil {
+		log.Errorf("Invalid replay config. Error: %#v.", err)
+		return nil, err
+	}
+	client, err := sarama.NewClient(cfg.Url, config)
+	if err != nil {
+		log.Errorf("Invoke NewClient failed. Error: %#v.", err)
+		return nil, err
+	}
+
+	return &Replayer{
+		client:  client,
+		groupId: cfg.GroupId,
+	}, nil
+}
+
+func (r *Replayer) Close() error {
+	return r.client.Close()
+}
+
+// 通过每个分区的OffsetsForTimes计算回放范围：从时间不早于start的第一条消息，到时间晚于end的第一条消息之前
+// 按偏移量范围回放，范围内时间戳不单调的消息也会被处理
+func (r *Replayer) Ranges(topic string, start, end time.Time) ([]*ReplayRange, error) {
+	if end.Before(start) {
+		return nil, fmt.Errorf("replay end %v before start %v", end, start)
+	}
+	partitions, err := r.client.Partitions(topic)
+	if err != nil {
+		log.Errorf("Invoke Partitions failed. Topic: %s, Error: %#v.", topic, err)
+		return nil, err
+	}
+
+	ranges := make([]*ReplayRange, 0, len(partitions))
+	for _, p := range partitions {
+		from, err := r.offsetForTime(topic, p, start)
+		if err != nil {
+			return nil, err
+		}
+		to, err := r.offsetForTime(topic, p, end.Add(time.Millisecond))
+		if err != nil {
+			return nil, err
+		}
+		if from < 0 {
+			continue // 没有start之后的消息
+		}
+		if to < 0 {
+			// 没有end之后的消息，回放到当前最新位置
+			if to, err = r.client.GetOffset(topic, p, sarama.OffsetNewest); err != nil {
+				log.Errorf("Invoke GetOffset failed. Topic: %s, Partition: %v, Error: %#v.", topic, p, err)
+				return nil, err
+			}
+		}
+		if from < to {
+			ranges = append(ranges, &ReplayRange{Partition: p, Start: from, End: to})
+		}
+	}
+
+	return ranges, nil
+}
+
+// 时间不早于t的第一条消息的偏移量，不存在时返回-1
+func (r *Replayer) offsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	offset, err := r.client.GetOffset(topic, partition, t.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		log.Errorf("Invoke GetOffset failed. Topic: %s, Partition: %v, Time: %v, Error: %#v.", topic, partition, t, err)
+		return 0, err
+	}
+
+	return offset, nil
+}
+
+// 回放[start, end]时间范围内的消息，各分区并行、分区内按顺序调用handler，所有分区到达结束位置后返回
+// handler返回错误或ctx取消时停止回放并返回错误，配置GroupId时已处理的消息会被提交
+func (r *Replayer) Replay(ctx context.Context, topic string, start, end time.Time,
+	handler func(m *sarama.ConsumerMessage) error) (*ReplayResult, error) {
+	ranges, err := r.Ranges(topic, start, end)
+	if err != nil {
+		return nil, err
+	}
+
+	var om sarama.OffsetManager
+	if r.groupId != "" {
+		if om, err = sarama.NewOffsetManagerFromClient(r.groupId, r.client); err != nil {
+			log.Errorf("Invoke NewOffsetManagerFromClient failed. GroupID: %s, Error: %#v.", r.groupId, err)
+			return nil, err
+		}
+		defer om.Close()
+	}
+	consumer, err := sarama.NewConsumerFromClient(r.client)
+	if err != nil {
+		log.Errorf("Invoke NewConsumerFromClient failed. Error: %#v.", err)
+		return nil, err
+	}
+	defer consumer.Close()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	result := &ReplayResult{Ranges: ranges, Messages: make(map[int32]int64, len(ranges))}
+	var mu sync.Mutex
+	var firstErr error
+	var wg sync.WaitGroup
+	for _, rg := range ranges {
+		wg.Add(1)
+		go func(rg *ReplayRange) {
+			defer wg.Done()
+			n, err := r.replayPartition(ctx, consumer, om, topic, rg, handler)
+			mu.Lock()
+			defer mu.Unlock()
+			result.Messages[rg.Partition] = n
+			if err != nil && firstErr == nil {
+				firstErr = err
+				cancel()
+			}
+		}(rg)
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return result, firstErr
+	}
+	log.Infof("Replay success. Topic: %s, Start: %v, End: %v, Messages: %v.", topic, start, end, result.Messages)
+
+	return result, nil
+}
+
+func (r *Replayer) replayPartition(ctx context.Context, consumer sarama.Consumer, om sarama.OffsetManager, topic string,
+	rg *ReplayRange, handler func(m *sarama.ConsumerMessage) error) (int64, error) {
+	offset := rg.Start
+	if om != nil {
+		pom, err := om.ManagePartition(topic, rg.Partition)
+		if err != nil {
+			log.Errorf("Invoke ManagePartition failed. Topic: %s, Partition: %v, Error: %#v.", topic, rg.Partition, err)
+			return 0, err
+		}
+		defer pom.Close()
+		if next, _ := pom.NextOffset(); next > offset && next <= rg.End {
+			offset = next
+			rg.Resumed = true
+		}
+		handler = markAfter(pom, handler)
+	}
+	if offset >= rg.End {
+		return 0, nil
+	}
+
+	pc, err := consumer.ConsumePartition(topic, rg.Partition, offset)
+	if err != nil {
+		log.Errorf("Invoke ConsumePartition failed. Topic: %s, Partition: %v, Offset: %v, Error: %#v.", topic, rg.Partition, offset, err)
+		return 0, err
+	}
+	defer pc.Close()
+
+	var n int64
+	idle := time.NewTimer(replayIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return n, ctx.Err()
+		case e := <-pc.Errors():
+			log.Errorf("Replay partition failed. Topic: %s, Partition: %v, Error: %#v.", topic, rg.Partition, e)
+			return n, e
+		case <-idle.C:
+			if pc.HighWaterMarkOffset() >= rg.End {
+				return n, nil
+			}
+			idle.Reset(replayIdleTimeout)
+		case msg := <-pc.Messages():
+			if msg.Offset >= rg.End {
+				return n, nil
+			}
+			if err := handler(msg); err != nil {
+				log.Errorf("Replay handler failed. Topic: %s, Partition: %v, Offset: %v, Error: %v.", topic, rg.Partition, msg.Offset, err)
+				return n, err
+			}
+			n++
+			if msg.Offset >= rg.End-1 {
+				return n, nil
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(replayIdleTimeout)
+		}
+	}
+}
+
+// 处理成功后标记偏移量，关闭时提交
+func markAfter(pom sarama.PartitionOffsetManager, handler func(m *sarama.ConsumerMessage) error) func(m *sarama.ConsumerMessage) error {
+	return func(m *sarama.ConsumerMessage) error {
+		if err := handler(m); err != nil {
+			return err
+		}
+		pom.MarkOffset(m.Offset+1, "")
+		return nil
+	}
+}
@@ -0,0 +1,59 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestReplay(t *testing.T) {
+	start := time.Unix(1000, 0)
+	end := start.Add(time.Minute)
+	ms := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+
+	broker := sarama.NewMockBroker(t, 1)
+	defer broker.Close()
+	fetch := sarama.NewMockFetchResponse(t, 10)
+	for i := int64(0); i < 10; i++ {
+		fetch.SetMessage("orders", 0, i, sarama.StringEncoder(fmt.Sprint(i)))
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("orders", 0, broker.BrokerID()).
+			SetLeader("orders", 1, broker.BrokerID()),
+		"OffsetRequest": sarama.NewMockOffsetResponse(t).
+			SetOffset("orders", 0, ms(start), 3).
+			SetOffset("orders", 0, ms(end)+1, 7).
+			SetOffset("orders", 0, sarama.OffsetNewest, 10).
+			SetOffset("orders", 0, sarama.OffsetOldest, 0).
+			SetOffset("orders", 1, ms(start), -1).
+			SetOffset("orders", 1, ms(end)+1, -1).
+			SetOffset("orders", 1, sarama.OffsetNewest, 5),
+		"FetchRequest": fetch,
+	})
+
+	replayer, err := NewReplayer(&Config{Url: []string{broker.Addr()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer replayer.Close()
+
+	values := make([]string, 0)
+	result, err := replayer.Replay(context.Background(), "orders", start, end, func(m *sarama.ConsumerMessage) error {
+		values = append(values, string(m.Value))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Ranges) != 1 || result.Ranges[0].Start != 3 || result.Ranges[0].End != 7 {
+		t.Errorf("ranges = %+v", result.Ranges[0])
+	}
+	if got := fmt.Sprint(values); got != "[3 4 5 6]" {
+		t.Errorf("values = %s", got)
+	}
+}
@@ -0,0 +1,192 @@
+package kafka
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	CorrelationIdHeader = "kafka_correlation_id" // 请求和响应的关联ID
+	ReplyTopicHeader    = "kafka_reply_topic"    // 请求方接收响应的topic
+	ReplyErrorHeader    = "kafka_reply_error"    // 服务端处理失败时的错误信息
+
+	defaultRequestTimeout = 30 * time.Second
+)
+
+var ErrRequestTimeout = errors.New("kafka: request timeout waiting for reply")
+
+// 服务端处理请求返回的错误
+type ReplyError struct {
+	Message string
+}
+
+func (e *ReplyError) Error() string {
+	return "kafka: reply error: " + e.Message
+}
+
+// 请求方：发送带关联ID和reply topic的请求，在reply topic上等待对应的响应
+// 每个实例需要使用独立的reply topic，consumer订阅该topic，使用独立的消费组并从Newest开始消费
+// 分配到分区前写入的响应不会被消费到，NewRequester会等待consumer分配到分区后再返回
+type Requester struct {
+	producer   Producer
+	consumer   Consumer
+	replyTopic string
+	timeout    time.Duration
+	mu         sync.Mutex
+	pending    map[string]chan *sarama.ConsumerMessage
+}
+
+// 等待分配到分区的消费者，如KafkaClusterConsumer
+type assignmentWaiter interface {
+	WaitAssigned(ctx context.Context) error
+}
+
+// timeout为ctx没有截止时间时的默认超时时间，不大于0时为30秒，也是等待consumer分配到分区的超时时间
+// consumer为KafkaClusterConsumer时需要先调用CheckConsumeResult，超时未分配到分区时返回错误
+func NewRequester(producer Producer, consumer Consumer, replyTopic string, timeout time.Duration) (*Requester, error) {
+	if timeout <= 0 {
+		timeout = defaultRequestTimeout
+	}
+	r := &Requester{
+		producer:   producer,
+		consumer:   consumer,
+		replyTopic: replyTopic,
+		timeout:    timeout,
+		pending:    make(map[string]chan *sarama.ConsumerMessage),
+	}
+	consumer.ListenMsg(r.onReply)
+
+	if w, ok := consumer.(assignmentWaiter); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		defer cancel()
+		if err := w.WaitAssigned(ctx); err != nil {
+			log.Errorf("Requester wait reply topic assigned failed. Topic: %s, Error: %v.", replyTopic, err)
+			return nil, err
+		}
+	}
+
+	return r, nil
+}
+
+// 发送请求并等待响应，超时返回ErrRequestTimeout，服务端处理失败时返回ReplyError
+func (r *Requester) Request(ctx context.Context, topic, key string, value interface{}) (*sarama.ConsumerMessage, error) {
+	return r.RequestMessage(ctx, &Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	})
+}
+
+func (r *Requester) RequestMessage(ctx context.Context, m *Message) (*sarama.ConsumerMessage, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.timeout)
+		defer cancel()
+	}
+
+	id, err := newCorrelationId()
+	if err != nil {
+		return nil, err
+	}
+	headers := make(map[string]string, len(m.Headers)+2)
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	headers[CorrelationIdHeader] = id
+	headers[ReplyTopicHeader] = r.replyTopic
+	req := *m
+	req.Headers = headers
+
+	// 先登记再发送，避免响应先于登记到达
+	ch := make(chan *sarama.ConsumerMessage, 1)
+	r.mu.Lock()
+	r.pending[id] = ch
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		delete(r.pending, id)
+		r.mu.Unlock()
+	}()
+
+	if err := r.producer.SendMessage(&req); err != nil {
+		return nil, err
+	}
+
+	select {
+	case reply := <-ch:
+		if msg := Header(reply, ReplyErrorHeader); msg != "" {
+			return reply, &ReplyError{Message: msg}
+		}
+		return reply, nil
+	case <-ctx.Done():
+		log.Errorf("Request timeout. Topic: %s, CorrelationId: %s, Error: %v.", m.Topic, id, ctx.Err())
+		if ctx.Err() == context.DeadlineExceeded {
+			return nil, ErrRequestTimeout
+		}
+		return nil, ctx.Err()
+	}
+}
+
+// 关闭接收响应的消费者，生产者由调用方关闭
+func (r *Requester) Close() error {
+	return r.consumer.Close()
+}
+
+func (r *Requester) onReply(m *sarama.ConsumerMessage) {
+	id := Header(m, CorrelationIdHeader)
+	r.mu.Lock()
+	ch, ok := r.pending[id]
+	r.mu.Unlock()
+	if !ok {
+		log.Debugf("Drop reply without pending request. CorrelationId: %s, Offset: %v.", id, m.Offset)
+		return
+	}
+
+	select {
+	case ch <- m:
+	default:
+	}
+}
+
+func newCorrelationId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// 服务端：调用handler处理请求，并将返回值发送到请求中的reply topic，handler返回错误时回复错误信息
+// 没有reply topic的消息只处理不回复
+func ReplyHandler(producer Producer, handler func(m *sarama.ConsumerMessage) (interface{}, error)) HandlerFunc {
+	return func(m *sarama.ConsumerMessage) {
+		value, err := handler(m)
+
+		replyTopic := Header(m, ReplyTopicHeader)
+		if replyTopic == "" {
+			return
+		}
+		reply := &Message{
+			Topic:   replyTopic,
+			Key:     string(m.Key),
+			Value:   value,
+			Headers: map[string]string{CorrelationIdHeader: Header(m, CorrelationIdHeader)},
+		}
+		if err != nil {
+			reply.Value = sarama.ByteEncoder(nil)
+			reply.Headers[ReplyErrorHeader] = err.Error()
+		}
+		if err := producer.SendMessage(reply); err != nil {
+			log.Errorf("Send reply failed. Topic: %s, CorrelationId: %s, Error: %#v.",
+				replyTopic, reply.Headers[CorrelationIdHeader], err)
+		}
+	}
+}
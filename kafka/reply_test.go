@@ -0,0 +1,108 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/bsm/sarama-cluster"
+)
+
+func TestRequestReply(t *testing.T) {
+	broker := NewMemoryBroker(2, "")
+	producer := broker.NewProducer()
+
+	server := broker.NewConsumer("calc", "add")
+	server.ListenMsg(ReplyHandler(producer, func(m *sarama.ConsumerMessage) (interface{}, error) {
+		var args []int
+		if err := json.Unmarshal(m.Value, &args); err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			return nil, errors.New("no args")
+		}
+		return args[0] + args[1], nil
+	}))
+	defer server.Close()
+
+	requester, err := NewRequester(producer, broker.NewConsumer("instance-1", "reply-1"), "reply-1", time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer requester.Close()
+
+	reply, err := requester.Request(context.Background(), "add", "k", []int{1, 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(reply.Value) != "3" {
+		t.Errorf("reply = %s", reply.Value)
+	}
+
+	if _, err := requester.Request(context.Background(), "add", "k", []int{}); err == nil {
+		t.Error("expected reply error")
+	} else if re, ok := err.(*ReplyError); !ok || re.Message != "no args" {
+		t.Errorf("err = %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := requester.Request(ctx, "nobody", "k", 1); err != ErrRequestTimeout {
+		t.Errorf("err = %v", err)
+	}
+}
+
+// 通过Notifications通知分区分配结果
+type notifyingConsumer struct {
+	*fakeClusterConsumer
+	notifications chan *cluster.Notification
+}
+
+func (c *notifyingConsumer) Notifications() <-chan *cluster.Notification {
+	return c.notifications
+}
+
+func TestNewRequesterWaitAssigned(t *testing.T) {
+	newConsumer := func() (*KafkaClusterConsumer, chan *cluster.Notification) {
+		kcc, fc := newBatchConsumer()
+		notifications := make(chan *cluster.Notification, 1)
+		kcc.consumer = &notifyingConsumer{fakeClusterConsumer: fc, notifications: notifications}
+		kcc.assigned = make(chan struct{})
+		kcc.CheckConsumeResult()
+		return kcc, notifications
+	}
+	producer := NewMemoryBroker(1, "").NewProducer()
+
+	// 分配到分区后才返回
+	kcc, notifications := newConsumer()
+	defer kcc.Close()
+	created := make(chan error, 1)
+	go func() {
+		_, err := NewRequester(producer, kcc, "reply-1", time.Second)
+		created <- err
+	}()
+	select {
+	case err := <-created:
+		t.Fatalf("returned before assigned, err = %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	notifications <- &cluster.Notification{Type: cluster.RebalanceOK, Current: map[string][]int32{"reply-1": {0}}}
+	select {
+	case err := <-created:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("not returned after assigned")
+	}
+
+	// 超时未分配到分区时返回错误
+	kcc, _ = newConsumer()
+	defer kcc.Close()
+	if _, err := NewRequester(producer, kcc, "reply-1", 50*time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("err = %v", err)
+	}
+}
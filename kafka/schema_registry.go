@@ -0,0 +1,188 @@
+package kafka
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// schema类型
+const (
+	SchemaTypeAvro     = "AVRO"
+	SchemaTypeProtobuf = "PROTOBUF"
+)
+
+const schemaRegistryContentType = "application/vnd.schemaregistry.v1+json"
+
+// 注册中心中的schema
+type Schema struct {
+	Id         int    `json:"id"`
+	Subject    string `json:"subject,omitempty"`
+	Version    int    `json:"version,omitempty"`
+	Schema     string `json:"schema"`
+	SchemaType string `json:"schemaType,omitempty"` // 为空时为AVRO
+}
+
+// 注册中心返回的错误
+type SchemaRegistryError struct {
+	StatusCode int
+	ErrorCode  int    `json:"error_code"`
+	Message    string `json:"message"`
+}
+
+func (e *SchemaRegistryError) Error() string {
+	return fmt.Sprintf("schema registry: status %d, error code %d: %s", e.StatusCode, e.ErrorCode, e.Message)
+}
+
+// 兼容Confluent Schema Registry REST接口的客户端，按id和subject缓存schema
+type SchemaRegistryClient struct {
+	url    string
+	client *http.Client
+	mu     sync.RWMutex
+	byId   map[int]*Schema
+	ids    map[string]int // subject + schema -> id
+}
+
+func NewSchemaRegistryClient(registryUrl string, timeout time.Duration) *SchemaRegistryClient {
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+
+	return &SchemaRegistryClient{
+		url:    strings.TrimRight(registryUrl, "/"),
+		client: &http.Client{Timeout: timeout},
+		byId:   make(map[int]*Schema),
+		ids:    make(map[string]int),
+	}
+}
+
+// 注册schema，返回schema id，已注册过时返回原id
+func (c *SchemaRegistryClient) Register(subject, schema, schemaType string) (int, error) {
+	cacheKey := subject + "\x00" + schemaType + "\x00" + schema
+	c.mu.RLock()
+	id, ok := c.ids[cacheKey]
+	c.mu.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	req := &Schema{Schema: schema, SchemaType: normalizeSchemaType(schemaType)}
+	resp := &Schema{}
+	if err := c.do(http.MethodPost, "/subjects/"+url.PathEscape(subject)+"/versions", req, resp); err != nil {
+		log.Errorf("Register schema failed. Subject: %s, Error: %v.", subject, err)
+		return 0, err
+	}
+
+	c.mu.Lock()
+	c.ids[cacheKey] = resp.Id
+	c.byId[resp.Id] = &Schema{Id: resp.Id, Subject: subject, Schema: schema, SchemaType: req.SchemaType}
+	c.mu.Unlock()
+
+	return resp.Id, nil
+}
+
+// 按id查询schema
+func (c *SchemaRegistryClient) GetSchema(id int) (*Schema, error) {
+	c.mu.RLock()
+	s, ok := c.byId[id]
+	c.mu.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	s = &Schema{}
+	if err := c.do(http.MethodGet, fmt.Sprintf("/schemas/ids/%d", id), nil, s); err != nil {
+		return nil, err
+	}
+	s.Id = id
+
+	c.mu.Lock()
+	c.byId[id] = s
+	c.mu.Unlock()
+
+	return s, nil
+}
+
+// 查询subject的最新版本，不缓存
+func (c *SchemaRegistryClient) LatestSchema(subject string) (*Schema, error) {
+	s := &Schema{}
+	if err := c.do(http.MethodGet, "/subjects/"+url.PathEscape(subject)+"/versions/latest", nil, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// 检查schema与subject的最新版本是否兼容，subject不存在时认为兼容
+func (c *SchemaRegistryClient) CheckCompatibility(subject, schema, schemaType string) (bool, error) {
+	req := &Schema{Schema: schema, SchemaType: normalizeSchemaType(schemaType)}
+	resp := &struct {
+		IsCompatible bool `json:"is_compatible"`
+	}{}
+	err := c.do(http.MethodPost, "/compatibility/subjects/"+url.PathEscape(subject)+"/versions/latest", req, resp)
+	if e, ok := err.(*SchemaRegistryError); ok && e.StatusCode == http.StatusNotFound {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return resp.IsCompatible, nil
+}
+
+func (c *SchemaRegistryClient) do(method, path string, req, resp interface{}) error {
+	var body *bytes.Reader
+	if req != nil {
+		b, err := json.Marshal(req)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	} else {
+		body = bytes.NewReader(nil)
+	}
+
+	httpReq, err := http.NewRequest(method, c.url+path, body)
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", schemaRegistryContentType)
+	httpReq.Header.Set("Accept", schemaRegistryContentType)
+
+	httpResp, err := c.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer httpResp.Body.Close()
+
+	data, err := ioutil.ReadAll(httpResp.Body)
+	if err != nil {
+		return err
+	}
+	if httpResp.StatusCode < 200 || httpResp.StatusCode >= 300 {
+		e := &SchemaRegistryError{StatusCode: httpResp.StatusCode}
+		if json.Unmarshal(data, e) != nil || e.Message == "" {
+			e.Message = string(data)
+		}
+		return e
+	}
+
+	return json.Unmarshal(data, resp)
+}
+
+// 注册中心默认类型为AVRO，请求中不传
+func normalizeSchemaType(schemaType string) string {
+	if schemaType == SchemaTypeAvro {
+		return ""
+	}
+
+	return schemaType
+}
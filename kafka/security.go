@@ -0,0 +1,136 @@
+package kafka
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+)
+
+// SASL认证方式
+const (
+	SASLPlain       = "PLAIN"
+	SASLScramSHA256 = "SCRAM-SHA-256"
+	SASLScramSHA512 = "SCRAM-SHA-512"
+)
+
+// 连接kafka的安全配置，不配置时使用明文连接
+type SecurityConfig struct {
+	TLS           bool   `ini:"tls" yaml:"tls"`                       //是否使用TLS连接
+	CAFile        string `ini:"ca_file" yaml:"ca_file"`               //CA证书文件，为空时使用系统CA
+	CertFile      string `ini:"cert_file" yaml:"cert_file"`           //客户端证书文件，双向认证时配置
+	KeyFile       string `ini:"key_file" yaml:"key_file"`             //客户端私钥文件，双向认证时配置
+	SkipVerify    bool   `ini:"skip_verify" yaml:"skip_verify"`       //不校验服务端证书，仅用于开发环境
+	SASLMechanism string `ini:"sasl_mechanism" yaml:"sasl_mechanism"` //SASL认证方式（PLAIN、SCRAM-SHA-256、SCRAM-SHA-512），为空时不使用SASL
+	SASLUser      string `ini:"sasl_user" yaml:"sasl_user"`           //SASL用户名
+	SASLPassword  string `ini:"sasl_password" yaml:"sasl_password"`   //SASL密码
+}
+
+// 将安全配置应用到sarama配置
+func applySecurity(config *sarama.Config, sc *SecurityConfig) error {
+	if !sc.TLS && (sc.CAFile != "" || sc.CertFile != "" || sc.KeyFile != "" || sc.SkipVerify) {
+		return errors.New("tls options configured but tls not enabled")
+	}
+	if sc.TLS {
+		tlsConfig, err := newTLSConfig(sc)
+		if err != nil {
+			return err
+		}
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = tlsConfig
+	}
+
+	if sc.SASLMechanism == "" {
+		if sc.SASLUser != "" || sc.SASLPassword != "" {
+			return errors.New("sasl user configured but sasl mechanism not configured")
+		}
+		return nil
+	}
+	if sc.SASLUser == "" {
+		return errors.New("sasl user not configured")
+	}
+	config.Net.SASL.Enable = true
+	config.Net.SASL.Handshake = true
+	config.Net.SASL.User = sc.SASLUser
+	config.Net.SASL.Password = sc.SASLPassword
+	switch sc.SASLMechanism {
+	case SASLPlain:
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case SASLScramSHA256:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: scram.SHA256}
+		}
+	case SASLScramSHA512:
+		config.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		config.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hashGenerator: scram.SHA512}
+		}
+	default:
+		return fmt.Errorf("sasl mechanism %s not support", sc.SASLMechanism)
+	}
+	if !config.Version.IsAtLeast(sarama.V0_10_2_0) {
+		config.Version = sarama.V0_10_2_0
+	}
+
+	return nil
+}
+
+func newTLSConfig(sc *SecurityConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: sc.SkipVerify,
+	}
+
+	if sc.CAFile != "" {
+		ca, err := ioutil.ReadFile(sc.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("invalid ca file %s", sc.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if (sc.CertFile == "") != (sc.KeyFile == "") {
+		return nil, errors.New("cert file and key file must be configured together")
+	}
+	if sc.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(sc.CertFile, sc.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// sarama.SCRAMClient的实现
+type scramClient struct {
+	hashGenerator scram.HashGeneratorFcn
+	conversation  *scram.ClientConversation
+}
+
+func (c *scramClient) Begin(userName, password, authzID string) error {
+	client, err := c.hashGenerator.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	c.conversation = client.NewConversation()
+
+	return nil
+}
+
+func (c *scramClient) Step(challenge string) (string, error) {
+	return c.conversation.Step(challenge)
+}
+
+func (c *scramClient) Done() bool {
+	return c.conversation.Done()
+}
@@ -0,0 +1,149 @@
+package kafka
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+// 生成自签名证书和私钥文件
+func writeTestCert(t *testing.T, dir string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "kafka-test"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile = filepath.Join(dir, "client.pem")
+	keyFile = filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return certFile, keyFile
+}
+
+func TestBuildClientConfigSecurity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka-security")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	cfg := &Config{
+		Url:     []string{"127.0.0.1:9093"},
+		Version: "0.10.0.0",
+		Security: SecurityConfig{
+			TLS:      true,
+			CAFile:   certFile,
+			CertFile: certFile,
+			KeyFile:  keyFile,
+		},
+	}
+	config, err := cfg.BuildClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !config.Net.TLS.Enable || config.Net.TLS.Config.RootCAs == nil || len(config.Net.TLS.Config.Certificates) != 1 {
+		t.Errorf("tls config = %+v", config.Net.TLS)
+	}
+	if config.Net.SASL.Enable || config.Version != sarama.V0_10_0_0 {
+		t.Error("sasl should not be enabled without mechanism")
+	}
+
+	mechanisms := map[string]sarama.SASLMechanism{
+		SASLPlain:       sarama.SASLTypePlaintext,
+		SASLScramSHA256: sarama.SASLTypeSCRAMSHA256,
+		SASLScramSHA512: sarama.SASLTypeSCRAMSHA512,
+	}
+	for name, mechanism := range mechanisms {
+		cfg := &Config{
+			Url:      []string{"127.0.0.1:9092"},
+			Version:  "0.10.0.0",
+			Security: SecurityConfig{SASLMechanism: name, SASLUser: "jcs", SASLPassword: "secret"},
+		}
+		config, err := cfg.BuildClientConfig()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if !config.Net.SASL.Enable || config.Net.SASL.Mechanism != mechanism || config.Net.SASL.User != "jcs" {
+			t.Errorf("%s: sasl config = %+v", name, config.Net.SASL)
+		}
+		// SASL需要0.10.2.0以上版本
+		if !config.Version.IsAtLeast(sarama.V0_10_2_0) {
+			t.Errorf("%s: version = %v", name, config.Version)
+		}
+		if name == SASLPlain {
+			if config.Net.SASL.SCRAMClientGeneratorFunc != nil {
+				t.Errorf("%s: unexpected scram client", name)
+			}
+			continue
+		}
+		client := config.Net.SASL.SCRAMClientGeneratorFunc()
+		if err := client.Begin("jcs", "secret", ""); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		first, err := client.Step("")
+		if err != nil || !strings.HasPrefix(first, "n,,n=jcs,r=") || client.Done() {
+			t.Errorf("%s: first message = %q, err = %v", name, first, err)
+		}
+	}
+}
+
+func TestBuildClientConfigSecurityInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kafka-security")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	certFile, keyFile := writeTestCert(t, dir)
+
+	invalid := map[string]SecurityConfig{
+		"unsupported mechanism":   {SASLMechanism: "GSSAPI", SASLUser: "jcs"},
+		"mechanism without user":  {SASLMechanism: SASLScramSHA512},
+		"user without mechanism":  {SASLUser: "jcs", SASLPassword: "secret"},
+		"tls options without tls": {CAFile: certFile},
+		"cert without key":        {TLS: true, CertFile: certFile},
+		"key without cert":        {TLS: true, KeyFile: keyFile},
+		"missing ca file":         {TLS: true, CAFile: filepath.Join(dir, "missing.pem")},
+		"invalid ca file":         {TLS: true, CAFile: keyFile},
+	}
+	for name, sc := range invalid {
+		cfg := &Config{Url: []string{"127.0.0.1:9092"}, Security: sc}
+		if _, err := cfg.BuildClientConfig(); err == nil {
+			t.Errorf("%s should be rejected", name)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package kafka
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+	"github.com/linkedin/goavro/v2"
+	"google.golang.org/protobuf/proto"
+)
+
+// 注册中心wire format的魔数，消息格式为 魔数(1字节) + schema id(4字节大端) + 数据
+const schemaMagicByte = 0
+
+var ErrInvalidWireFormat = errors.New("kafka: invalid schema registry wire format")
+
+// 消息序列化，设置到生产者后，非sarama.Encoder类型的消息值使用它编码
+type Serializer interface {
+	Serialize(topic string, value interface{}) ([]byte, error)
+}
+
+// 消息反序列化，将消息值解码到v
+// 消费者不会自动解码，通过KafkaClusterConsumer.SetDeserializer设置后在处理函数中调用Decode，或直接调用DecodeMessage
+type Deserializer interface {
+	Deserialize(topic string, data []byte, v interface{}) error
+}
+
+// 解码消费到的消息值，用于ListenBatch、Replay等拿不到消费者的处理函数
+func DecodeMessage(d Deserializer, msg *sarama.ConsumerMessage, v interface{}) error {
+	return d.Deserialize(msg.Topic, msg.Value, v)
+}
+
+// 默认的subject命名规则，与Confluent的TopicNameStrategy一致
+func TopicSubject(topic string) string {
+	return topic + "-value"
+}
+
+// 解析wire format，返回schema id和数据
+func ParseWireFormat(data []byte) (int, []byte, error) {
+	if len(data) < 5 || data[0] != schemaMagicByte {
+		return 0, nil, ErrInvalidWireFormat
+	}
+
+	return int(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+}
+
+func appendWireHeader(buf []byte, id int) []byte {
+	buf = append(buf, schemaMagicByte, 0, 0, 0, 0)
+	binary.BigEndian.PutUint32(buf[len(buf)-4:], uint32(id))
+
+	return buf
+}
+
+// 写入前先检查与最新版本兼容再注册，每个subject只检查一次
+type schemaWriter struct {
+	registry   *SchemaRegistryClient
+	schema     string
+	schemaType string
+	mu         sync.Mutex
+	ids        map[string]int // subject -> id
+}
+
+func (w *schemaWriter) schemaId(topic string) (int, error) {
+	subject := TopicSubject(topic)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if id, ok := w.ids[subject]; ok {
+		return id, nil
+	}
+
+	ok, err := w.registry.CheckCompatibility(subject, w.schema, w.schemaType)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		log.Errorf("Schema incompatible with latest version. Subject: %s.", subject)
+		return 0, fmt.Errorf("kafka: schema incompatible with latest version of subject %s", subject)
+	}
+	id, err := w.registry.Register(subject, w.schema, w.schemaType)
+	if err != nil {
+		return 0, err
+	}
+	w.ids[subject] = id
+
+	return id, nil
+}
+
+// Avro序列化，写入时使用指定的schema，读取时使用消息中schema id对应的schema
+// 值为map[string]interface{}时直接编码，其他类型先转换为json再按Avro的json格式解析，union类型需要使用{"type": value}的形式
+type AvroSerde struct {
+	writer schemaWriter
+	codec  *goavro.Codec
+	mu     sync.RWMutex
+	codecs map[int]*goavro.Codec
+}
+
+func NewAvroSerde(registry *SchemaRegistryClient, schema string) (*AvroSerde, error) {
+	codec, err := goavro.NewCodec(schema)
+	if err != nil {
+		log.Errorf("Invalid avro schema. Error: %v.", err)
+		return nil, err
+	}
+
+	return &AvroSerde{
+		writer: schemaWriter{
+			registry:   registry,
+			schema:     codec.Schema(),
+			schemaType: SchemaTypeAvro,
+			ids:        make(map[string]int),
+		},
+		codec:  codec,
+		codecs: make(map[int]*goavro.Codec),
+	}, nil
+}
+
+func (s *AvroSerde) Serialize(topic string, value interface{}) ([]byte, error) {
+	id, err := s.writer.schemaId(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	native := value
+	if _, ok := value.(map[string]interface{}); !ok {
+		text, err := json.Marshal(value)
+		if err != nil {
+			return nil, err
+		}
+		// schema不是record时解析结果不是map，如string、array
+		if native, _, err = s.codec.NativeFromTextual(text); err != nil {
+			return nil, err
+		}
+	}
+
+	return s.codec.BinaryFromNative(appendWireHeader(nil, id), native)
+}
+
+// v为*map[string]interface{}时直接返回解码结果，其他类型通过json转换
+func (s *AvroSerde) Deserialize(topic string, data []byte, v interface{}) error {
+	id, payload, err := ParseWireFormat(data)
+	if err != nil {
+		return err
+	}
+	codec, err := s.readerCodec(id)
+	if err != nil {
+		return err
+	}
+	native, _, err := codec.NativeFromBinary(payload)
+	if err != nil {
+		return err
+	}
+
+	if m, ok := v.(*map[string]interface{}); ok {
+		if *m, ok = native.(map[string]interface{}); !ok {
+			return fmt.Errorf("kafka: avro value is %T, not a record", native)
+		}
+		return nil
+	}
+	text, err := codec.TextualFromNative(nil, native)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(text, v)
+}
+
+func (s *AvroSerde) readerCodec(id int) (*goavro.Codec, error) {
+	s.mu.RLock()
+	codec, ok := s.codecs[id]
+	s.mu.RUnlock()
+	if ok {
+		return codec, nil
+	}
+
+	schema, err := s.writer.registry.GetSchema(id)
+	if err != nil {
+		return nil, err
+	}
+	if schema.SchemaType != "" && schema.SchemaType != SchemaTypeAvro {
+		return nil, fmt.Errorf("kafka: schema %d is %s, not avro", id, schema.SchemaType)
+	}
+	codec, err = goavro.NewCodec(schema.Schema)
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.codecs[id] = codec
+	s.mu.Unlock()
+
+	return codec, nil
+}
+
+// Protobuf序列化，值需要是proto.Message，schema为.proto文件内容，只支持文件中的第一个消息类型
+type ProtobufSerde struct {
+	writer schemaWriter
+}
+
+func NewProtobufSerde(registry *SchemaRegistryClient, schema string) *ProtobufSerde {
+	return &ProtobufSerde{
+		writer: schemaWriter{
+			registry:   registry,
+			schema:     schema,
+			schemaType: SchemaTypeProtobuf,
+			ids:        make(map[string]int),
+		},
+	}
+}
+
+func (s *ProtobufSerde) Serialize(topic string, value interface{}) ([]byte, error) {
+	pm, ok := value.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("kafka: %T is not a proto.Message", value)
+	}
+	id, err := s.writer.schemaId(topic)
+	if err != nil {
+		return nil, err
+	}
+
+	// schema id后是消息类型在文件中的索引，第一个消息类型简写为0
+	buf := append(appendWireHeader(nil, id), 0)
+
+	return proto.MarshalOptions{}.MarshalAppend(buf, pm)
+}
+
+func (s *ProtobufSerde) Deserialize(topic string, data []byte, v interface{}) error {
+	pm, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("kafka: %T is not a proto.Message", v)
+	}
+	_, payload, err := ParseWireFormat(data)
+	if err != nil {
+		return err
+	}
+
+	// 跳过消息类型索引：数量 + 各级索引，均为zigzag编码的varint
+	n, size := binary.Varint(payload)
+	if size <= 0 || n < 0 {
+		return ErrInvalidWireFormat
+	}
+	payload = payload[size:]
+	for i := int64(0); i < n; i++ {
+		if _, size = binary.Varint(payload); size <= 0 {
+			return ErrInvalidWireFormat
+		}
+		payload = payload[size:]
+	}
+
+	return proto.Unmarshal(payload, pm)
+}
@@ -0,0 +1,143 @@
+package kafka
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 模拟注册中心，只保存每个subject的schema，名称包含incompatible的schema不兼容
+func newFakeRegistry() *httptest.Server {
+	var mu sync.Mutex
+	schemas := make([]*Schema, 0)
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		req := &Schema{}
+		json.NewDecoder(r.Body).Decode(req)
+		parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+		switch {
+		case r.Method == http.MethodPost && parts[0] == "subjects":
+			for _, s := range schemas {
+				if s.Subject == parts[1] && s.Schema == req.Schema {
+					json.NewEncoder(w).Encode(map[string]int{"id": s.Id})
+					return
+				}
+			}
+			req.Id, req.Subject = len(schemas)+1, parts[1]
+			schemas = append(schemas, req)
+			json.NewEncoder(w).Encode(map[string]int{"id": req.Id})
+		case r.Method == http.MethodPost && parts[0] == "compatibility":
+			json.NewEncoder(w).Encode(map[string]bool{"is_compatible": !strings.Contains(req.Schema, "incompatible")})
+		case r.Method == http.MethodGet && parts[0] == "schemas":
+			for _, s := range schemas {
+				if parts[2] == strconv.Itoa(s.Id) {
+					json.NewEncoder(w).Encode(&Schema{Schema: s.Schema, SchemaType: s.SchemaType})
+					return
+				}
+			}
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"error_code":40403,"message":"Schema not found"}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+}
+
+type instanceMsg struct {
+	ResourceId string   `json:"resource_id"`
+	Action     string   `json:"action"`
+	Resources  []string `json:"resources"`
+}
+
+const instanceSchema = `{"type":"record","name":"InstanceMsg","fields":[
+	{"name":"resource_id","type":"string"},
+	{"name":"action","type":"string"},
+	{"name":"resources","type":{"type":"array","items":"string"}}]}`
+
+func TestAvroSerde(t *testing.T) {
+	server := newFakeRegistry()
+	defer server.Close()
+	registry := NewSchemaRegistryClient(server.URL, 0)
+
+	serde, err := NewAvroSerde(registry, instanceSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := &instanceMsg{ResourceId: "i-abc", Action: "InstanceJoinToAg", Resources: []string{"i-1", "i-2"}}
+	msg, err := (&Message{Topic: "instance", Value: in}).encodeWith(serde)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := msg.Value.Encode()
+	if id, _, err := ParseWireFormat(data); err != nil || id != 1 {
+		t.Fatalf("id = %d, err = %v", id, err)
+	}
+
+	// 使用新的客户端解码，验证按id查询schema
+	reader, _ := NewAvroSerde(NewSchemaRegistryClient(server.URL, 0), instanceSchema)
+	out := &instanceMsg{}
+	if err := DecodeMessage(reader, &sarama.ConsumerMessage{Topic: "instance", Value: data}, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.ResourceId != in.ResourceId || out.Action != in.Action || len(out.Resources) != 2 {
+		t.Errorf("decoded %+v", out)
+	}
+
+	// 通过消费者解码
+	kcc := &KafkaClusterConsumer{}
+	kcc.SetDeserializer(reader)
+	out = &instanceMsg{}
+	if err := kcc.Decode(&sarama.ConsumerMessage{Topic: "instance", Value: data}, out); err != nil || out.ResourceId != in.ResourceId {
+		t.Errorf("decoded %+v, err = %v", out, err)
+	}
+
+	// schema不是record
+	names, err := NewAvroSerde(registry, `{"type":"array","items":"string"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err = names.Serialize("names", []string{"i-1", "i-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []string
+	if err := names.Deserialize("names", data, &decoded); err != nil || len(decoded) != 2 || decoded[1] != "i-2" {
+		t.Errorf("decoded %v, err = %v", decoded, err)
+	}
+
+	bad, _ := NewAvroSerde(registry, `{"type":"record","name":"incompatible","fields":[]}`)
+	if _, err := bad.Serialize("instance", map[string]interface{}{}); err == nil {
+		t.Error("incompatible schema should fail")
+	}
+}
+
+func TestProtobufSerde(t *testing.T) {
+	server := newFakeRegistry()
+	defer server.Close()
+
+	serde := NewProtobufSerde(NewSchemaRegistryClient(server.URL, 0),
+		`syntax = "proto3"; message StringValue { string value = 1; }`)
+	data, err := serde.Serialize("names", wrapperspb.String("jvirt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := &wrapperspb.StringValue{}
+	if err := serde.Deserialize("names", data, out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Value != "jvirt" {
+		t.Errorf("decoded %q", out.Value)
+	}
+	if err := serde.Deserialize("names", []byte("{}"), out); err != ErrInvalidWireFormat {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,91 @@
+package kafka
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	ErrProducerClosed = errors.New("producer is closed")
+	ErrConsumerClosed = errors.New("consumer is closed")
+)
+
+// 关闭超时或关闭过程中有消息发送失败
+type ShutdownError struct {
+	Client  string // 客户端类型
+	Pending int64  // 未完成的消息数
+	Failed  int64  // 关闭过程中发送失败的消息数
+	Err     error  // 超时时为ctx.Err()
+}
+
+func (e *ShutdownError) Error() string {
+	msg := fmt.Sprintf("%s shutdown: %d pending, %d failed", e.Client, e.Pending, e.Failed)
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+
+	return msg
+}
+
+// 可以优雅关闭的客户端，KafkaClusterConsumer、KafkaSyncProducer、KafkaAsyncProducer 满足该接口
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// 按顺序关闭clients，共用ctx的截止时间，一般先关闭消费者再关闭生产者
+func ShutdownAll(ctx context.Context, clients ...Shutdowner) error {
+	errs := make([]string, 0)
+	for _, c := range clients {
+		if c == nil {
+			continue
+		}
+		if err := c.Shutdown(ctx); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return errors.New(strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// 阻塞直到收到SIGINT或SIGTERM，然后在timeout内按顺序关闭clients
+func ShutdownOnSignal(timeout time.Duration, clients ...Shutdowner) error {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	sig := <-signals
+	log.Infof("Receive signal %v, shutdown kafka clients.", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return ShutdownAll(ctx, clients...)
+}
+
+// 等待wg完成，ctx到期时返回false
+func waitGroupWithContext(ctx context.Context, wg *sync.WaitGroup) bool {
+	finished := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
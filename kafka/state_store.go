@@ -0,0 +1,224 @@
+package kafka
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 恢复changelog时等待新消息的时间，超时后检查是否已读到高水位
+const restoreIdleTimeout = 5 * time.Second
+
+// 流处理的本地状态：进程内的key-value存储，每次修改先写入changelog topic，重启后从changelog恢复
+// changelog topic需要配置为compact，可以使用ChangelogTopicSpec通过EnsureTopics创建
+// 状态不按分区划分，一个changelog只能由一个实例使用，多个实例需要各自使用不同的changelog
+type StateStore struct {
+	mu        sync.RWMutex
+	data      map[string][]byte
+	producer  Producer
+	changelog string
+}
+
+// producer为nil时不写changelog，只保存在内存中
+func NewStateStore(producer Producer, changelog string) *StateStore {
+	return &StateStore{
+		data:      make(map[string][]byte),
+		producer:  producer,
+		changelog: changelog,
+	}
+}
+
+// changelog topic的配置，只保留每个key的最新值
+func ChangelogTopicSpec(changelog string, partitions int32, replicationFactor int16) *TopicSpec {
+	return &TopicSpec{
+		Name:              changelog,
+		Partitions:        partitions,
+		ReplicationFactor: replicationFactor,
+		CleanupPolicy:     "compact",
+	}
+}
+
+// 从changelog topic的最早位置读取到当前最新位置，恢复所有key的最新值，需要在开始处理消息之前调用
+// 读取changelog的所有分区，不区分消费者分配到的分区
+func (st *StateStore) Restore(cfg *Config) error {
+	config, err := cfg.BuildClientConfig()
+	if err != nil {
+		log.Errorf("Invalid restore config. Error: %#v.", err)
+		return err
+	}
+	client, err := sarama.NewClient(cfg.Url, config)
+	if err != nil {
+		log.Errorf("Invoke NewClient failed. Error: %#v.", err)
+		return err
+	}
+	defer client.Close()
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		log.Errorf("Invoke NewConsumerFromClient failed. Error: %#v.", err)
+		return err
+	}
+	defer consumer.Close()
+
+	partitions, err := client.Partitions(st.changelog)
+	if err != nil {
+		log.Errorf("Invoke Partitions failed. Topic: %s, Error: %#v.", st.changelog, err)
+		return err
+	}
+	restored := 0
+	for _, p := range partitions {
+		n, err := st.restorePartition(client, consumer, p)
+		if err != nil {
+			return err
+		}
+		restored += n
+	}
+	log.Infof("Restore state store success. Changelog: %s, Messages: %d, Keys: %d.", st.changelog, restored, st.Len())
+
+	return nil
+}
+
+func (st *StateStore) restorePartition(client sarama.Client, consumer sarama.Consumer, partition int32) (int, error) {
+	hwm, err := client.GetOffset(st.changelog, partition, sarama.OffsetNewest)
+	if err != nil {
+		log.Errorf("Invoke GetOffset failed. Topic: %s, Partition: %v, Error: %#v.", st.changelog, partition, err)
+		return 0, err
+	}
+	oldest, err := client.GetOffset(st.changelog, partition, sarama.OffsetOldest)
+	if err != nil {
+		log.Errorf("Invoke GetOffset failed. Topic: %s, Partition: %v, Error: %#v.", st.changelog, partition, err)
+		return 0, err
+	}
+	if oldest >= hwm {
+		return 0, nil
+	}
+
+	pc, err := consumer.ConsumePartition(st.changelog, partition, oldest)
+	if err != nil {
+		log.Errorf("Invoke ConsumePartition failed. Topic: %s, Partition: %v, Error: %#v.", st.changelog, partition, err)
+		return 0, err
+	}
+	defer pc.Close()
+
+	// 分区最后是事务控制消息等不会被消费到的消息时，没有新消息且高水位已到达hwm后结束
+	n := 0
+	idle := time.NewTimer(restoreIdleTimeout)
+	defer idle.Stop()
+	for {
+		select {
+		case msg := <-pc.Messages():
+			st.apply(msg)
+			n++
+			if msg.Offset >= hwm-1 {
+				return n, nil
+			}
+			if !idle.Stop() {
+				<-idle.C
+			}
+			idle.Reset(restoreIdleTimeout)
+		case <-idle.C:
+			if pc.HighWaterMarkOffset() >= hwm {
+				return n, nil
+			}
+			idle.Reset(restoreIdleTimeout)
+		case e := <-pc.Errors():
+			log.Errorf("Restore changelog failed. Topic: %s, Partition: %v, Error: %#v.", st.changelog, partition, e)
+			return n, e
+		}
+	}
+}
+
+// 应用一条changelog消息，值为空时删除
+func (st *StateStore) apply(msg *sarama.ConsumerMessage) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if msg.Value == nil {
+		delete(st.data, string(msg.Key))
+		return
+	}
+	st.data[string(msg.Key)] = msg.Value
+}
+
+func (st *StateStore) Get(key string) ([]byte, bool) {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	v, ok := st.data[key]
+
+	return v, ok
+}
+
+// 写入changelog成功后再更新内存
+func (st *StateStore) Put(key string, value []byte) error {
+	if value == nil {
+		value = []byte{}
+	}
+	if err := st.writeChangelog(key, value); err != nil {
+		return err
+	}
+	st.mu.Lock()
+	st.data[key] = value
+	st.mu.Unlock()
+
+	return nil
+}
+
+// 删除key，在changelog中写入value为空的消息，compact后该key被清除
+func (st *StateStore) Delete(key string) error {
+	if err := st.writeChangelog(key, nil); err != nil {
+		return err
+	}
+	st.mu.Lock()
+	delete(st.data, key)
+	st.mu.Unlock()
+
+	return nil
+}
+
+func (st *StateStore) writeChangelog(key string, value []byte) error {
+	if st.producer == nil {
+		return nil
+	}
+	err := st.producer.SendMessage(&Message{
+		Topic: st.changelog,
+		Key:   key,
+		Value: sarama.ByteEncoder(value),
+	})
+	if err != nil {
+		log.Errorf("Write changelog failed. Topic: %s, Key: %s, Error: %#v.", st.changelog, key, err)
+	}
+
+	return err
+}
+
+// 按key的顺序遍历前缀为prefix的key，fn返回false时停止
+func (st *StateStore) Range(prefix string, fn func(key string, value []byte) bool) {
+	st.mu.RLock()
+	keys := make([]string, 0, len(st.data))
+	for k := range st.data {
+		if strings.HasPrefix(k, prefix) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	values := make([][]byte, len(keys))
+	for i, k := range keys {
+		values[i] = st.data[k]
+	}
+	st.mu.RUnlock()
+
+	for i, k := range keys {
+		if !fn(k, values[i]) {
+			return
+		}
+	}
+}
+
+func (st *StateStore) Len() int {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+
+	return len(st.data)
+}
@@ -0,0 +1,317 @@
+package kafka
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 流中的一条记录，Value初始为消息内容，可以在Map中替换为任意类型
+type Record struct {
+	Key       string
+	Value     interface{}
+	Timestamp time.Time               // 消息时间，用于划分窗口
+	Msg       *sarama.ConsumerMessage // 原始消息
+}
+
+// 时间窗口，Size等于Advance时为滚动窗口，Advance小于Size时为跳跃窗口，一条记录属于多个窗口
+type Window struct {
+	Size      time.Duration
+	Advance   time.Duration
+	Retention time.Duration // 窗口结束后保留多久，之后到达的记录被丢弃，窗口从状态中删除，默认为Size
+}
+
+// 滚动窗口：[0, size)、[size, 2*size)...
+func TumblingWindow(size time.Duration) Window {
+	return Window{Size: size, Advance: size}
+}
+
+// 跳跃窗口：每advance开始一个长度为size的窗口
+func HoppingWindow(size, advance time.Duration) Window {
+	return Window{Size: size, Advance: advance}
+}
+
+func (w Window) validate() error {
+	if w.Size <= 0 {
+		return fmt.Errorf("kafka: window size %v must be positive", w.Size)
+	}
+	return nil
+}
+
+func (w Window) retention() time.Duration {
+	if w.Retention > 0 {
+		return w.Retention
+	}
+	return w.Size
+}
+
+// 包含t的所有窗口的开始时间，按时间从早到晚排序
+func (w Window) starts(t time.Time) []time.Time {
+	ns := t.UnixNano()
+	last := ns - ns%int64(w.Advance)
+	starts := make([]time.Time, 0, int(w.Size/w.Advance)+1)
+	for s := last; s > ns-int64(w.Size); s -= int64(w.Advance) {
+		starts = append([]time.Time{time.Unix(0, s)}, starts...)
+	}
+
+	return starts
+}
+
+// 窗口状态在StateStore中的key：<key>@<窗口开始的毫秒时间戳>
+func WindowKey(key string, start time.Time) string {
+	return key + "@" + strconv.FormatInt(start.UnixNano()/int64(time.Millisecond), 10)
+}
+
+func parseWindowKey(wk string) (string, time.Time, bool) {
+	i := strings.LastIndex(wk, "@")
+	if i < 0 {
+		return "", time.Time{}, false
+	}
+	ms, err := strconv.ParseInt(wk[i+1:], 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	return wk[:i], time.Unix(0, ms*int64(time.Millisecond)), true
+}
+
+// 一个窗口的聚合结果
+type WindowValue struct {
+	Start time.Time
+	End   time.Time
+	Value []byte
+}
+
+// 查询key在所有未过期窗口的聚合结果，按窗口开始时间排序
+func FetchWindows(store *StateStore, key string, w Window) []*WindowValue {
+	values := make([]*WindowValue, 0)
+	store.Range(key+"@", func(wk string, v []byte) bool {
+		if k, start, ok := parseWindowKey(wk); ok && k == key {
+			values = append(values, &WindowValue{Start: start, End: start.Add(w.Size), Value: v})
+		}
+		return true
+	})
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Start.Before(values[j].Start)
+	})
+
+	return values
+}
+
+// 解析Count的结果
+func ParseCount(v []byte) int64 {
+	n, _ := strconv.ParseInt(string(v), 10, 64)
+	return n
+}
+
+// 基于消费者的轻量流处理：对消息依次执行Map、Filter、KeyBy，最后由一个终结操作处理
+// 终结操作（Foreach、To、Table、Count、Aggregate）调用消费者的ListenMsg，每个Stream只能有一个终结操作
+// KeyBy只在本实例内重新分组，多实例时相同的新key需要由同一个实例处理，否则先按新key写入中间topic再聚合
+// 处理完每条消息后都会标记偏移量：状态更新或发送失败时只记录日志，该记录对结果的更新丢失
+// 重启后未提交的消息会被重复处理，聚合结果可能偏大
+type Stream struct {
+	consumer Consumer
+	ops      []func(r *Record) bool // 返回false时丢弃记录
+}
+
+func NewStream(consumer Consumer) *Stream {
+	return &Stream{
+		consumer: consumer,
+	}
+}
+
+// 转换记录，返回nil时丢弃
+func (s *Stream) Map(fn func(r *Record) *Record) *Stream {
+	s.ops = append(s.ops, func(r *Record) bool {
+		nr := fn(r)
+		if nr == nil {
+			return false
+		}
+		*r = *nr
+		return true
+	})
+
+	return s
+}
+
+// 只保留fn返回true的记录
+func (s *Stream) Filter(fn func(r *Record) bool) *Stream {
+	s.ops = append(s.ops, fn)
+
+	return s
+}
+
+// 使用fn的返回值作为记录的key，用于后续按key聚合
+func (s *Stream) KeyBy(fn func(r *Record) string) *Stream {
+	s.ops = append(s.ops, func(r *Record) bool {
+		r.Key = fn(r)
+		return true
+	})
+
+	return s
+}
+
+// 对每条经过处理的记录调用fn
+func (s *Stream) Foreach(fn func(r *Record)) {
+	s.consumer.ListenMsg(func(m *sarama.ConsumerMessage) {
+		r := &Record{
+			Key:       string(m.Key),
+			Value:     m.Value,
+			Timestamp: m.Timestamp,
+			Msg:       m,
+		}
+		if r.Timestamp.IsZero() {
+			r.Timestamp = time.Now()
+		}
+		for _, op := range s.ops {
+			if !op(r) {
+				return
+			}
+		}
+		fn(r)
+	})
+}
+
+// 将记录写入topic，保留原消息头
+func (s *Stream) To(producer Producer, topic string) {
+	s.Foreach(func(r *Record) {
+		value := r.Value
+		if b, ok := value.([]byte); ok {
+			value = sarama.ByteEncoder(b)
+		}
+		err := producer.SendMessage(&Message{
+			Topic:     topic,
+			Key:       r.Key,
+			Value:     value,
+			Headers:   Headers(r.Msg),
+			Timestamp: r.Timestamp,
+		})
+		if err != nil {
+			log.Errorf("Stream send failed. Topic: %s, Key: %s, Error: %#v.", topic, r.Key, err)
+		}
+	})
+}
+
+// 保存每个key的最新值，Value为空（如tombstone消息）时删除该key
+func (s *Stream) Table(store *StateStore) {
+	s.Foreach(func(r *Record) {
+		var b []byte
+		var err error
+		if r.Value != nil {
+			b, err = recordBytes(r.Value)
+		}
+		if err == nil {
+			if b == nil {
+				err = store.Delete(r.Key)
+			} else {
+				err = store.Put(r.Key, b)
+			}
+		}
+		if err != nil {
+			log.Errorf("Stream update table failed. Key: %s, Error: %#v.", r.Key, err)
+		}
+	})
+}
+
+// 按key统计每个窗口的记录数，结果使用FetchWindows查询、ParseCount解析
+func (s *Stream) Count(w Window, store *StateStore) error {
+	return s.Aggregate(w, store, func(agg []byte, r *Record) ([]byte, error) {
+		return []byte(strconv.FormatInt(ParseCount(agg)+1, 10)), nil
+	})
+}
+
+// 按key和窗口聚合，agg为窗口的当前值，窗口第一条记录时为nil
+// 超过Retention的窗口被删除，属于已删除窗口的迟到记录被丢弃，窗口的Size不大于0时返回错误
+// 只删除本次运行中写入过的窗口，store可以与Table共用；重启前写入且之后没有再更新的窗口不会被删除
+func (s *Stream) Aggregate(w Window, store *StateStore, fn func(agg []byte, r *Record) ([]byte, error)) error {
+	if err := w.validate(); err != nil {
+		log.Errorf("Invalid stream window. Error: %v.", err)
+		return err
+	}
+	if w.Advance <= 0 || w.Advance > w.Size {
+		w.Advance = w.Size
+	}
+	wa := &windowAggregator{window: w, store: store, fn: fn, windows: make(map[string]time.Time)}
+	s.Foreach(wa.add)
+
+	return nil
+}
+
+type windowAggregator struct {
+	mu          sync.Mutex
+	window      Window
+	store       *StateStore
+	fn          func(agg []byte, r *Record) ([]byte, error)
+	streamTime  time.Time // 已处理记录的最大时间
+	nextCleanup time.Time
+	windows     map[string]time.Time // 写入过的窗口及开始时间，清理时只删除这些key，不影响共用store的Table等
+}
+
+func (wa *windowAggregator) add(r *Record) {
+	wa.mu.Lock()
+	defer wa.mu.Unlock()
+
+	if r.Timestamp.After(wa.streamTime) {
+		wa.streamTime = r.Timestamp
+	}
+	for _, start := range wa.window.starts(r.Timestamp) {
+		if wa.expired(start) {
+			log.Debugf("Stream drop late record. Key: %s, Timestamp: %v.", r.Key, r.Timestamp)
+			continue
+		}
+		wk := WindowKey(r.Key, start)
+		agg, _ := wa.store.Get(wk)
+		v, err := wa.fn(agg, r)
+		if err == nil {
+			err = wa.store.Put(wk, v)
+		}
+		if err == nil {
+			wa.windows[wk] = start
+		}
+		if err != nil {
+			log.Errorf("Stream aggregate failed. Key: %s, Window: %v, Error: %#v.", r.Key, start, err)
+		}
+	}
+
+	if wa.streamTime.After(wa.nextCleanup) {
+		wa.cleanup()
+		wa.nextCleanup = wa.streamTime.Add(wa.window.Advance)
+	}
+}
+
+func (wa *windowAggregator) expired(start time.Time) bool {
+	return !start.Add(wa.window.Size + wa.window.retention()).After(wa.streamTime)
+}
+
+// 删除写入过的过期窗口
+func (wa *windowAggregator) cleanup() {
+	for wk, start := range wa.windows {
+		if !wa.expired(start) {
+			continue
+		}
+		if err := wa.store.Delete(wk); err != nil {
+			return
+		}
+		delete(wa.windows, wk)
+	}
+}
+
+func recordBytes(v interface{}) ([]byte, error) {
+	switch v := v.(type) {
+	case []byte:
+		return v, nil
+	case string:
+		return []byte(v), nil
+	case sarama.Encoder:
+		return v.Encode()
+	default:
+		return json.Marshal(v)
+	}
+}
@@ -0,0 +1,133 @@
+package kafka
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWindowStarts(t *testing.T) {
+	ts := time.Unix(0, int64(65*time.Second))
+	if got := fmt.Sprint(TumblingWindow(time.Minute).starts(ts)); got != fmt.Sprint([]time.Time{time.Unix(60, 0)}) {
+		t.Errorf("tumbling: %s", got)
+	}
+	want := []time.Time{time.Unix(30, 0), time.Unix(45, 0), time.Unix(60, 0)}
+	if got := fmt.Sprint(HoppingWindow(time.Minute/2, 15*time.Second).starts(ts)); got != fmt.Sprint(want[1:]) {
+		t.Errorf("hopping: %s", got)
+	}
+	if got := fmt.Sprint(HoppingWindow(45*time.Second, 15*time.Second).starts(ts)); got != fmt.Sprint(want) {
+		t.Errorf("hopping: %s", got)
+	}
+}
+
+func TestStreamCount(t *testing.T) {
+	broker := NewMemoryBroker(2, PartitionerHash)
+	producer := broker.NewProducer()
+	base := time.Unix(600, 0)
+	events := []struct {
+		user, action string
+		at           time.Duration
+	}{
+		{"u1", "click", 0}, {"u1", "view", time.Second}, {"u2", "click", 2 * time.Second},
+		{"u1", "click", 3 * time.Second}, {"u1", "click", 61 * time.Second}, {"u2", "click", 62 * time.Second},
+	}
+	for _, e := range events {
+		m := &Message{Topic: "events", Key: e.user, Value: e.action, Timestamp: base.Add(e.at)}
+		if err := producer.SendMessage(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store := NewStateStore(producer, "counts-changelog")
+	if err := NewStream(broker.NewConsumer("invalid", "events")).Count(Window{}, store); err == nil {
+		t.Fatal("window without size should be rejected")
+	}
+	consumer := broker.NewConsumer("counter", "events")
+	err := NewStream(consumer).
+		Filter(func(r *Record) bool { return string(r.Value.([]byte)) == `"click"` }).
+		KeyBy(func(r *Record) string { return "clicks:" + r.Key }).
+		Count(TumblingWindow(time.Minute), store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for broker.Lag("counter", "events") > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := consumer.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int64)
+	for _, key := range []string{"clicks:u1", "clicks:u2"} {
+		for _, wv := range FetchWindows(store, key, TumblingWindow(time.Minute)) {
+			counts[fmt.Sprintf("%s@%d", key, wv.Start.Unix())] = ParseCount(wv.Value)
+		}
+	}
+	want := map[string]int64{"clicks:u1@600": 2, "clicks:u1@660": 1, "clicks:u2@600": 1, "clicks:u2@660": 1}
+	if fmt.Sprint(counts) != fmt.Sprint(want) {
+		t.Errorf("counts = %v, want %v", counts, want)
+	}
+
+	// 从changelog恢复的状态与原状态一致
+	restored := NewStateStore(nil, "counts-changelog")
+	for _, msg := range broker.Messages("counts-changelog") {
+		restored.apply(msg)
+	}
+	if restored.Len() != store.Len() {
+		t.Fatalf("restored %d keys, want %d", restored.Len(), store.Len())
+	}
+	store.Range("", func(k string, v []byte) bool {
+		if rv, _ := restored.Get(k); string(rv) != string(v) {
+			t.Errorf("%s: restored %s, want %s", k, rv, v)
+		}
+		return true
+	})
+}
+
+func TestWindowCleanup(t *testing.T) {
+	store := NewStateStore(nil, "")
+	// 与Table共用store，key的格式与窗口相同
+	store.Put("order@60000", []byte("paid"))
+	wa := &windowAggregator{
+		window:  TumblingWindow(time.Minute),
+		store:   store,
+		windows: make(map[string]time.Time),
+		fn: func(agg []byte, r *Record) ([]byte, error) {
+			return []byte("1"), nil
+		},
+	}
+	wa.add(&Record{Key: "u1", Timestamp: time.Unix(60, 0)})
+	wa.add(&Record{Key: "u1", Timestamp: time.Unix(3600, 0)})
+
+	// 只删除过期的窗口，不删除其他key
+	keys := make([]string, 0)
+	store.Range("", func(k string, v []byte) bool {
+		keys = append(keys, k)
+		return true
+	})
+	if fmt.Sprint(keys) != "[order@60000 u1@3600000]" {
+		t.Errorf("keys = %v", keys)
+	}
+}
+
+func TestStateStoreDelete(t *testing.T) {
+	broker := NewMemoryBroker(1, PartitionerHash)
+	store := NewStateStore(broker.NewProducer(), "table-changelog")
+	if err := store.Put("a", []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewStateStore(nil, "table-changelog")
+	for _, msg := range broker.Messages("table-changelog") {
+		restored.apply(msg)
+	}
+	if _, ok := restored.Get("a"); ok {
+		t.Error("deleted key restored")
+	}
+}
@@ -0,0 +1,276 @@
+package kafka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+	"gopkg.in/yaml.v2"
+)
+
+// 期望的topic配置，未配置的项不检查，使用broker的默认值
+type TopicSpec struct {
+	Name              string            `yaml:"name"`
+	Partitions        int32             `yaml:"partitions"`         //分区数，已有topic分区数较少时自动扩容
+	ReplicationFactor int16             `yaml:"replication_factor"` //副本数，只在创建时生效
+	Retention         time.Duration     `yaml:"retention"`          //消息保留时间，小于0时永久保留
+	CleanupPolicy     string            `yaml:"cleanup_policy"`     //清理策略（delete、compact、compact,delete）
+	MinCompactionLag  time.Duration     `yaml:"min_compaction_lag"` //compact时消息至少保留多久才会被压缩
+	Configs           map[string]string `yaml:"configs"`            //其他topic配置，如max.message.bytes
+}
+
+type topicSpecs struct {
+	Topics []*TopicSpec `yaml:"topics"`
+}
+
+// 从yaml文件加载topic配置，格式为 topics: [{name: xxx, partitions: 3, ...}]
+func LoadTopicSpecsFromYaml(file string) ([]*TopicSpec, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		log.Errorf("Read topic file failed. File: %s, Error: %#v.", file, err)
+		return nil, err
+	}
+	specs := &topicSpecs{}
+	if err := yaml.UnmarshalStrict(data, specs); err != nil {
+		log.Errorf("Invoke yaml Unmarshal failed. File: %s, Error: %#v.", file, err)
+		return nil, err
+	}
+
+	return specs.Topics, nil
+}
+
+func (s *TopicSpec) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("topic name not configured")
+	}
+	if s.Partitions <= 0 {
+		return fmt.Errorf("topic %s partitions should be positive", s.Name)
+	}
+	if s.ReplicationFactor <= 0 {
+		return fmt.Errorf("topic %s replication factor should be positive", s.Name)
+	}
+	if !contains([]string{"", "delete", "compact", "compact,delete", "delete,compact"}, s.CleanupPolicy) {
+		return fmt.Errorf("topic %s cleanup policy %s not support", s.Name, s.CleanupPolicy)
+	}
+
+	return nil
+}
+
+// 转换为topic配置项
+func (s *TopicSpec) configs() map[string]string {
+	configs := make(map[string]string, len(s.Configs)+3)
+	for k, v := range s.Configs {
+		configs[k] = v
+	}
+	if s.Retention < 0 {
+		configs["retention.ms"] = "-1"
+	} else if s.Retention > 0 {
+		configs["retention.ms"] = strconv.FormatInt(int64(s.Retention/time.Millisecond), 10)
+	}
+	if s.CleanupPolicy != "" {
+		configs["cleanup.policy"] = s.CleanupPolicy
+	}
+	if s.MinCompactionLag > 0 {
+		configs["min.compaction.lag.ms"] = strconv.FormatInt(int64(s.MinCompactionLag/time.Millisecond), 10)
+	}
+
+	return configs
+}
+
+// topic实际配置与期望不一致
+type TopicDrift struct {
+	Topic    string
+	Config   string // 配置项，分区数和副本数为partitions、replication_factor
+	Expected string
+	Actual   string
+}
+
+func (d *TopicDrift) String() string {
+	return fmt.Sprintf("%s %s: expected %s, actual %s", d.Topic, d.Config, d.Expected, d.Actual)
+}
+
+// EnsureTopics的结果
+type EnsureTopicsResult struct {
+	Created  []string         // 新创建的topic
+	Expanded map[string]int32 // 扩容的topic及扩容后的分区数
+	Drifts   []*TopicDrift    // 无法自动修正的差异，如分区数多于期望、副本数或配置不一致
+}
+
+// 按期望创建缺少的topic、扩容分区数不足的topic，并检查已有topic的副本数和配置差异
+// 已有topic的配置不会被修改，差异记录日志并在结果中返回，由人工确认后处理
+func (ka *KafkaAdmin) EnsureTopics(specs []*TopicSpec) (*EnsureTopicsResult, error) {
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			return nil, err
+		}
+	}
+
+	topics, err := ka.admin.ListTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &EnsureTopicsResult{
+		Expanded: make(map[string]int32),
+	}
+	for _, spec := range specs {
+		detail, ok := topics[spec.Name]
+		if !ok {
+			created, err := ka.createTopic(spec)
+			if err != nil {
+				return result, err
+			}
+			if created {
+				result.Created = append(result.Created, spec.Name)
+				continue
+			}
+			// 其他实例同时创建，按已有topic检查
+			if detail, err = ka.describeTopic(spec.Name); err != nil {
+				return result, err
+			}
+		}
+		if err := ka.ensureTopic(spec, detail, result); err != nil {
+			return result, err
+		}
+	}
+
+	for _, d := range result.Drifts {
+		log.Warnf("Topic config drift. %s.", d)
+	}
+
+	return result, nil
+}
+
+// 扩容已有topic的分区，并检查副本数和配置差异
+func (ka *KafkaAdmin) ensureTopic(spec *TopicSpec, detail sarama.TopicDetail, result *EnsureTopicsResult) error {
+	if detail.NumPartitions < spec.Partitions {
+		if err := ka.admin.CreatePartitions(spec.Name, spec.Partitions, nil, false); err != nil {
+			log.Errorf("Invoke CreatePartitions failed. Topic: %s, Error: %#v.", spec.Name, err)
+			return err
+		}
+		log.Infof("Expand topic partitions success. Topic: %s, Partitions: %d -> %d.", spec.Name, detail.NumPartitions, spec.Partitions)
+		result.Expanded[spec.Name] = spec.Partitions
+	} else if detail.NumPartitions > spec.Partitions {
+		result.Drifts = append(result.Drifts, &TopicDrift{spec.Name, "partitions",
+			fmt.Sprint(spec.Partitions), fmt.Sprint(detail.NumPartitions)})
+	}
+	if detail.ReplicationFactor != spec.ReplicationFactor {
+		result.Drifts = append(result.Drifts, &TopicDrift{spec.Name, "replication_factor",
+			fmt.Sprint(spec.ReplicationFactor), fmt.Sprint(detail.ReplicationFactor)})
+	}
+
+	drifts, err := ka.configDrifts(spec)
+	if err != nil {
+		return err
+	}
+	result.Drifts = append(result.Drifts, drifts...)
+
+	return nil
+}
+
+// 查询单个topic的分区数和副本数
+func (ka *KafkaAdmin) describeTopic(name string) (sarama.TopicDetail, error) {
+	detail := sarama.TopicDetail{}
+	metadata, err := ka.admin.DescribeTopics([]string{name})
+	if err != nil {
+		log.Errorf("Invoke DescribeTopics failed. Topic: %s, Error: %#v.", name, err)
+		return detail, err
+	}
+	for _, tm := range metadata {
+		if tm.Name != name {
+			continue
+		}
+		if tm.Err != sarama.ErrNoError {
+			log.Errorf("Describe topic failed. Topic: %s, Error: %#v.", name, tm.Err)
+			return detail, tm.Err
+		}
+		detail.NumPartitions = int32(len(tm.Partitions))
+		if len(tm.Partitions) > 0 {
+			detail.ReplicationFactor = int16(len(tm.Partitions[0].Replicas))
+		}
+		return detail, nil
+	}
+
+	return detail, sarama.ErrUnknownTopicOrPartition
+}
+
+// 创建topic，其他实例已创建时返回false
+func (ka *KafkaAdmin) createTopic(spec *TopicSpec) (bool, error) {
+	entries := make(map[string]*string)
+	for k, v := range spec.configs() {
+		v := v
+		entries[k] = &v
+	}
+	detail := &sarama.TopicDetail{
+		NumPartitions:     spec.Partitions,
+		ReplicationFactor: spec.ReplicationFactor,
+		ConfigEntries:     entries,
+	}
+	if err := ka.admin.CreateTopic(spec.Name, detail, false); err != nil {
+		if e, ok := err.(*sarama.TopicError); ok && e.Err == sarama.ErrTopicAlreadyExists {
+			log.Infof("Topic already exists. Topic: %s.", spec.Name)
+			return false, nil
+		}
+		log.Errorf("Invoke CreateTopic failed. Topic: %s, Error: %#v.", spec.Name, err)
+		return false, err
+	}
+	log.Infof("Create topic success. Topic: %s, Partitions: %d, ReplicationFactor: %d.", spec.Name, spec.Partitions, spec.ReplicationFactor)
+
+	return true, nil
+}
+
+// 比较期望的配置和topic的实际配置（包括默认值）
+func (ka *KafkaAdmin) configDrifts(spec *TopicSpec) ([]*TopicDrift, error) {
+	expected := spec.configs()
+	if len(expected) == 0 {
+		return nil, nil
+	}
+
+	entries, err := ka.admin.DescribeConfig(sarama.ConfigResource{
+		Type: sarama.TopicResource,
+		Name: spec.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+	actual := make(map[string]string, len(entries))
+	for _, entry := range entries {
+		actual[entry.Name] = entry.Value
+	}
+
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	drifts := make([]*TopicDrift, 0)
+	for _, name := range names {
+		v, ok := actual[name]
+		if ok && normalizeConfig(name, v) == normalizeConfig(name, expected[name]) {
+			continue
+		}
+		drifts = append(drifts, &TopicDrift{spec.Name, name, expected[name], v})
+	}
+
+	return drifts, nil
+}
+
+// cleanup.policy为列表，compact,delete与delete,compact相同
+func normalizeConfig(name, value string) string {
+	if name != "cleanup.policy" {
+		return value
+	}
+	policies := strings.Split(value, ",")
+	for i := range policies {
+		policies[i] = strings.TrimSpace(policies[i])
+	}
+	sort.Strings(policies)
+
+	return strings.Join(policies, ",")
+}
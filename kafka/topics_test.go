@@ -0,0 +1,150 @@
+package kafka
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+)
+
+func TestLoadTopicSpecs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "topics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "topics.yaml")
+	ioutil.WriteFile(file, []byte(`topics:
+  - name: iaas_jvirt_jcs_instance_change_state
+    partitions: 6
+    replication_factor: 3
+    retention: 168h
+  - name: instance_state
+    partitions: 3
+    replication_factor: 3
+    retention: -1s
+    cleanup_policy: compact
+    min_compaction_lag: 1h
+    configs:
+      max.message.bytes: "2097152"
+`), 0644)
+
+	specs, err := LoadTopicSpecsFromYaml(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(specs) != 2 {
+		t.Fatalf("specs = %d", len(specs))
+	}
+	for _, spec := range specs {
+		if err := spec.validate(); err != nil {
+			t.Error(err)
+		}
+	}
+	if c := specs[0].configs(); len(c) != 1 || c["retention.ms"] != "604800000" {
+		t.Errorf("configs = %v", c)
+	}
+	c := specs[1].configs()
+	if c["retention.ms"] != "-1" || c["cleanup.policy"] != "compact" ||
+		c["min.compaction.lag.ms"] != "3600000" || c["max.message.bytes"] != "2097152" {
+		t.Errorf("configs = %v", c)
+	}
+}
+
+// 依次返回existing中分区数的orders元数据（客户端初始化、ListTopics、DescribeTopics各一次），0为topic不存在，最后一个重复使用
+// orders的实际配置为cleanup.policy=delete,compact、retention.ms=1000
+func newTopicsBroker(t *testing.T, existing []int32, createErr sarama.KError) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	metadata := make([]interface{}, 0, len(existing))
+	for _, partitions := range existing {
+		metadata = append(metadata, ordersMetadata(t, broker, partitions))
+	}
+	configs := []interface{}{&sarama.DescribeConfigsResponse{
+		Resources: []*sarama.ResourceResponse{{
+			Name: "orders",
+			Type: sarama.TopicResource,
+			Configs: []*sarama.ConfigEntry{
+				{Name: "cleanup.policy", Value: "delete,compact"},
+				{Name: "retention.ms", Value: "1000"},
+			},
+		}},
+	}}
+	if existing[0] == 0 {
+		configs = append([]interface{}{&sarama.DescribeConfigsResponse{Resources: []*sarama.ResourceResponse{}}}, configs...)
+	}
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockSequence(metadata...),
+		"CreateTopicsRequest": sarama.NewMockWrapper(&sarama.CreateTopicsResponse{
+			Version:     2,
+			TopicErrors: map[string]*sarama.TopicError{"orders": {Err: createErr}},
+		}),
+		"CreatePartitionsRequest": sarama.NewMockCreatePartitionsResponse(t),
+		"DescribeConfigsRequest":  sarama.NewMockSequence(configs...),
+	})
+
+	return broker
+}
+
+// orders有partitions个分区的元数据
+func ordersMetadata(t *testing.T, broker *sarama.MockBroker, partitions int32) *sarama.MockMetadataResponse {
+	metadata := sarama.NewMockMetadataResponse(t).
+		SetBroker(broker.Addr(), broker.BrokerID()).
+		SetController(broker.BrokerID())
+	for i := int32(0); i < partitions; i++ {
+		metadata.SetLeader("orders", i, broker.BrokerID())
+	}
+
+	return metadata
+}
+
+func TestEnsureTopics(t *testing.T) {
+	spec := &TopicSpec{Name: "orders", Partitions: 3, ReplicationFactor: 1, Retention: time.Second, CleanupPolicy: "compact,delete"}
+	cases := []struct {
+		name      string
+		existing  []int32
+		createErr sarama.KError
+		spec      *TopicSpec
+		want      string
+	}{
+		{"create", []int32{0}, sarama.ErrNoError, spec, "created [orders], expanded map[], drifts []"},
+		// cleanup.policy顺序不同不算差异
+		{"expand", []int32{1}, sarama.ErrNoError, spec, "created [], expanded map[orders:3], drifts []"},
+		{"drift", []int32{4}, sarama.ErrNoError,
+			&TopicSpec{Name: "orders", Partitions: 3, ReplicationFactor: 3, Retention: 2 * time.Second, CleanupPolicy: "delete"},
+			"created [], expanded map[], drifts [orders partitions: expected 3, actual 4 " +
+				"orders replication_factor: expected 3, actual 1 orders cleanup.policy: expected delete, actual delete,compact " +
+				"orders retention.ms: expected 2000, actual 1000]"},
+		// 其他实例同时创建，按已有topic扩容和检查差异
+		{"already exists", []int32{0, 0, 1}, sarama.ErrTopicAlreadyExists, spec, "created [], expanded map[orders:3], drifts []"},
+	}
+	for _, c := range cases {
+		broker := newTopicsBroker(t, c.existing, c.createErr)
+		ka, err := NewKafkaAdmin(&Config{Url: []string{broker.Addr()}, Version: "1.0.0"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := ka.EnsureTopics([]*TopicSpec{c.spec})
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		got := fmt.Sprintf("created %v, expanded %v, drifts %v", result.Created, result.Expanded, result.Drifts)
+		if got != c.want {
+			t.Errorf("%s: %s, want %s", c.name, got, c.want)
+		}
+		requested := false
+		for _, rr := range broker.History() {
+			if _, ok := rr.Request.(*sarama.CreateTopicsRequest); ok {
+				requested = true
+			}
+		}
+		if requested != (c.existing[0] == 0) {
+			t.Errorf("%s: create topic requested = %v", c.name, requested)
+		}
+		ka.Close()
+		broker.Close()
+	}
+}
@@ -0,0 +1,220 @@
+package kafka
+
+import (
+	"context"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "jd.com/jvirt/jvirt-common/utils/kafka"
+
+// 使用W3C traceparent/tracestate和baggage在消息头中传递链路上下文
+var tracePropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// 使用全局的TracerProvider，调用方通过otel.SetTracerProvider配置
+func tracer() trace.Tracer {
+	return otel.Tracer(tracerName)
+}
+
+// 将ctx中的链路上下文写入消息头，不修改原消息头
+func InjectTrace(ctx context.Context, m *Message) {
+	injectTrace(ctx, m)
+}
+
+// ctx中没有链路上下文时不修改消息头，返回是否写入
+func injectTrace(ctx context.Context, m *Message) bool {
+	carrier := propagation.MapCarrier{}
+	tracePropagator.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return false
+	}
+	headers := make(map[string]string, len(m.Headers)+len(carrier))
+	for k, v := range m.Headers {
+		headers[k] = v
+	}
+	for k, v := range carrier {
+		headers[k] = v
+	}
+	m.Headers = headers
+
+	return true
+}
+
+// 从消费到的消息头中提取链路上下文
+func ExtractTrace(ctx context.Context, msg *sarama.ConsumerMessage) context.Context {
+	return tracePropagator.Extract(ctx, consumerMessageCarrier{msg})
+}
+
+// 开始发送消息的span并将链路上下文写入消息头
+// 未通过otel.SetTracerProvider配置时span不记录，也不写入消息头
+func startProducerSpan(ctx context.Context, m *Message) (*Message, trace.Span) {
+	ctx, span := tracer().Start(ctx, m.Topic+" publish",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationPublish,
+			semconv.MessagingDestinationName(m.Topic),
+			semconv.MessagingKafkaMessageKey(m.Key),
+		))
+	mm := *m
+	if !injectTrace(ctx, &mm) {
+		return m, span
+	}
+
+	return &mm, span
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// 同步发送，将ctx中的链路上下文写入消息头，并记录发送的span
+// Send、SendMessage等不带ctx的方法同样记录span并写入traceparent，但没有父span，每条消息开始一条新的链路
+func (ksp *KafkaSyncProducer) SendContext(ctx context.Context, topic, key string, value interface{}) error {
+	return ksp.SendMessageContext(ctx, &Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	})
+}
+
+func (ksp *KafkaSyncProducer) SendMessageContext(ctx context.Context, m *Message) error {
+	m, span := startProducerSpan(ctx, m)
+	err := ksp.sendMessage(m)
+	endSpan(span, err)
+
+	return err
+}
+
+// 异步发送，将ctx中的链路上下文写入消息头，span在CheckProduceResult收到发送结果时结束，AsyncSend等不带ctx的方法没有父span
+func (kap *KafkaAsyncProducer) AsyncSendContext(ctx context.Context, topic, key string, value interface{}) error {
+	return kap.AsyncSendMessageContext(ctx, &Message{
+		Topic: topic,
+		Key:   key,
+		Value: value,
+	}, nil)
+}
+
+func (kap *KafkaAsyncProducer) AsyncSendMessageContext(ctx context.Context, m *Message, cb DeliveryCallback) error {
+	m, span := startProducerSpan(ctx, m)
+	if !span.IsRecording() {
+		return kap.asyncSendMessage(m, cb)
+	}
+	err := kap.asyncSendMessage(m, func(r *DeliveryReport) {
+		if r.Err == nil {
+			span.SetAttributes(
+				semconv.MessagingKafkaDestinationPartition(int(r.Partition)),
+				semconv.MessagingKafkaMessageOffset(int(r.Offset)),
+			)
+		}
+		endSpan(span, r.Err)
+		if cb != nil {
+			cb(r)
+		}
+	})
+	if err != nil {
+		endSpan(span, err)
+	}
+
+	return err
+}
+
+// 带上下文的消息处理函数，ctx中包含TraceHandler创建的span，用于在处理函数中创建子span
+type ContextHandlerFunc func(ctx context.Context, m *sarama.ConsumerMessage)
+
+// 从消息头中提取链路上下文，开始处理消息的span，记录topic、分区、偏移量和消费组
+func startConsumerSpan(groupId string, m *sarama.ConsumerMessage) (context.Context, trace.Span) {
+	return tracer().Start(ExtractTrace(context.Background(), m), m.Topic+" process",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithAttributes(
+			semconv.MessagingSystemKafka,
+			semconv.MessagingOperationKey.String("process"),
+			semconv.MessagingDestinationName(m.Topic),
+			semconv.MessagingKafkaDestinationPartition(int(m.Partition)),
+			semconv.MessagingKafkaMessageOffset(int(m.Offset)),
+			semconv.MessagingKafkaConsumerGroup(groupId),
+			semconv.MessagingKafkaMessageKey(string(m.Key)),
+		))
+}
+
+// 处理函数panic时记录错误后继续panic，由Recover处理
+func endConsumerSpan(span trace.Span) {
+	if p := recover(); p != nil {
+		span.SetStatus(codes.Error, "panic")
+		span.End()
+		panic(p)
+	}
+	span.End()
+}
+
+// 为每条消息创建处理消息的span，通过Use添加：kcc.Use(Tracing(groupId))
+// 处理函数需要在span下创建子span时改用TraceHandler，两者不要同时使用
+func Tracing(groupId string) Middleware {
+	return func(next HandlerFunc) HandlerFunc {
+		return func(m *sarama.ConsumerMessage) {
+			_, span := startConsumerSpan(groupId, m)
+			defer endConsumerSpan(span)
+			next(m)
+		}
+	}
+}
+
+// 将带上下文的处理函数转换为HandlerFunc，创建的span与Tracing相同，并通过ctx传给fn
+func TraceHandler(groupId string, fn ContextHandlerFunc) HandlerFunc {
+	return func(m *sarama.ConsumerMessage) {
+		ctx, span := startConsumerSpan(groupId, m)
+		defer endConsumerSpan(span)
+		fn(ctx, m)
+	}
+}
+
+// 消息头中的trace id，header为空时取W3C traceparent中的trace id
+func messageTraceId(m *sarama.ConsumerMessage, header string) string {
+	if header != "" {
+		return Header(m, header)
+	}
+	if sc := trace.SpanContextFromContext(ExtractTrace(context.Background(), m)); sc.IsValid() {
+		return sc.TraceID().String()
+	}
+
+	return ""
+}
+
+// 消费消息头的TextMapCarrier
+type consumerMessageCarrier struct {
+	msg *sarama.ConsumerMessage
+}
+
+func (c consumerMessageCarrier) Get(key string) string {
+	return Header(c.msg, key)
+}
+
+func (c consumerMessageCarrier) Set(key, value string) {
+	for _, h := range c.msg.Headers {
+		if h != nil && string(h.Key) == key {
+			h.Value = []byte(value)
+			return
+		}
+	}
+	c.msg.Headers = append(c.msg.Headers, &sarama.RecordHeader{Key: []byte(key), Value: []byte(value)})
+}
+
+func (c consumerMessageCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.msg.Headers))
+	for _, h := range c.msg.Headers {
+		if h != nil {
+			keys = append(keys, string(h.Key))
+		}
+	}
+
+	return keys
+}
@@ -0,0 +1,88 @@
+package kafka
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Shopify/sarama"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	ctx, parent := tracer().Start(context.Background(), "request")
+	m, span := startProducerSpan(ctx, &Message{Topic: "orders", Key: "k", Value: "v"})
+	endSpan(span, nil)
+	parent.End()
+	if m.Headers["traceparent"] == "" {
+		t.Fatalf("headers = %v", m.Headers)
+	}
+
+	broker := NewMemoryBroker(1, "")
+	if _, _, err := broker.Produce(m); err != nil {
+		t.Fatal(err)
+	}
+	msg := broker.Messages("orders")[0]
+
+	var handlerSpan trace.SpanContext
+	TraceHandler("g", func(ctx context.Context, m *sarama.ConsumerMessage) {
+		handlerSpan = trace.SpanContextFromContext(ctx)
+	})(msg)
+
+	if handlerSpan.TraceID() != parent.SpanContext().TraceID() {
+		t.Errorf("trace id = %s, want %s", handlerSpan.TraceID(), parent.SpanContext().TraceID())
+	}
+	spans := recorder.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("spans = %d", len(spans))
+	}
+	if consumer := spans[2]; consumer.Parent().SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("consumer span parent = %s, want %s", consumer.Parent().SpanID(), span.SpanContext().SpanID())
+	}
+}
+
+func TestSendInjectTrace(t *testing.T) {
+	fake := &fakeSyncProducer{}
+	ksp := &KafkaSyncProducer{sp: fake}
+
+	// 未配置TracerProvider时不写入消息头
+	if err := ksp.Send("orders", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.sent[0].Headers) != 0 {
+		t.Errorf("headers without tracer provider = %v", fake.sent[0].Headers)
+	}
+
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+	if err := ksp.Send("orders", "k", "v"); err != nil {
+		t.Fatal(err)
+	}
+	msg := &sarama.ConsumerMessage{Topic: "orders", Key: []byte("k")}
+	for i := range fake.sent[1].Headers {
+		msg.Headers = append(msg.Headers, &fake.sent[1].Headers[i])
+	}
+	if Header(msg, "traceparent") == "" {
+		t.Fatalf("headers = %v", Headers(msg))
+	}
+
+	// 通过Use添加的Tracing中间件创建消费span，父span为发送的span
+	Chain(func(m *sarama.ConsumerMessage) {}, Tracing("g"))(msg)
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("spans = %d", len(spans))
+	}
+	if spans[1].Parent().SpanID() != spans[0].SpanContext().SpanID() || spans[1].SpanKind() != trace.SpanKindConsumer {
+		t.Errorf("consumer span parent = %s, want %s", spans[1].Parent().SpanID(), spans[0].SpanContext().SpanID())
+	}
+	if traceId := messageTraceId(msg, ""); traceId != spans[0].SpanContext().TraceID().String() {
+		t.Errorf("log trace id = %s", traceId)
+	}
+}
@@ -0,0 +1,204 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"sync/atomic"
+	"time"
+
+	"github.com/Shopify/sarama"
+	log "github.com/Sirupsen/logrus"
+)
+
+// 事务失败后重试处理同一条消息的间隔，每次失败后翻倍
+const (
+	txnRetryBackoff    = time.Second
+	txnMaxRetryBackoff = 30 * time.Second
+)
+
+var ErrNotTransactional = errors.New("producer is not transactional, TransactionalId not configured")
+
+// 幂等发送：broker根据producer id和序号去重，重试不会产生重复消息
+func setIdempotent(config *sarama.Config) {
+	config.Producer.Idempotent = true
+	config.Producer.RequiredAcks = sarama.WaitForAll
+	config.Net.MaxOpenRequests = 1
+	if !config.Version.IsAtLeast(sarama.V0_11_0_0) {
+		config.Version = sarama.V0_11_0_0
+	}
+}
+
+// 开启事务，需配置TransactionalId
+func (ksp *KafkaSyncProducer) BeginTxn() error {
+	if !ksp.sp.IsTransactional() {
+		return ErrNotTransactional
+	}
+
+	return ksp.sp.BeginTxn()
+}
+
+// 提交事务
+func (ksp *KafkaSyncProducer) CommitTxn() error {
+	return ksp.sp.CommitTxn()
+}
+
+// 回滚事务，事务内发送的消息对ReadCommitted的消费者不可见
+func (ksp *KafkaSyncProducer) AbortTxn() error {
+	return ksp.sp.AbortTxn()
+}
+
+// 将消费到的消息的偏移量加入事务，事务提交时一起提交到消费组groupId
+func (ksp *KafkaSyncProducer) AddMessageToTxn(msg *sarama.ConsumerMessage, groupId string) error {
+	return ksp.sp.AddMessageToTxn(msg, groupId, nil)
+}
+
+// 在事务中执行fn，fn返回错误时回滚，否则提交
+func (ksp *KafkaSyncProducer) ExecTxn(fn func() error) error {
+	if err := ksp.BeginTxn(); err != nil {
+		log.Errorf("KafkaSyncProducer BeginTxn failed. Error: %#v.", err)
+		return err
+	}
+
+	if err := fn(); err != nil {
+		if e := ksp.sp.AbortTxn(); e != nil {
+			log.Errorf("KafkaSyncProducer AbortTxn failed. Error: %#v.", e)
+		}
+		return err
+	}
+
+	if err := ksp.sp.CommitTxn(); err != nil {
+		log.Errorf("KafkaSyncProducer CommitTxn failed. Error: %#v.", err)
+		if ksp.sp.TxnStatus()&sarama.ProducerTxnFlagAbortableError != 0 {
+			if e := ksp.sp.AbortTxn(); e != nil {
+				log.Errorf("KafkaSyncProducer AbortTxn failed. Error: %#v.", e)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// 事务不可恢复，需要重新创建生产者
+func (ksp *KafkaSyncProducer) txnFatal() bool {
+	return ksp.sp.TxnStatus()&sarama.ProducerTxnFlagFatalError != 0
+}
+
+// 事务消费：每条消息在一个事务中处理，transformFunc中通过producer发送的消息与该消息的偏移量一起提交，实现exactly-once
+// 消费者需配置IsolationLevel为ReadCommitted，事务失败时退避后重试同一条消息，Shutdown时停止重试
+// transformFunc panic时回滚事务，记录日志后只提交该消息的偏移量，与ListenMsg一致继续消费后面的消息
+// 事务不可恢复时停止消费，Health返回停止的原因，需要关闭消费者和生产者后重新创建，未提交的消息会重新消费
+// 需要sarama 1.37及以上版本
+func (kcc *KafkaClusterConsumer) ListenTxnMsg(producer *KafkaSyncProducer, transformFunc func(m *sarama.ConsumerMessage, p *KafkaSyncProducer) error) {
+	kcc.mu.Lock()
+	defer kcc.mu.Unlock()
+	if kcc.running || kcc.stopErr != nil {
+		return
+	}
+	kcc.running = true
+	kcc.startFetch()
+
+	kcc.wg.Add(1)
+	go func() {
+		defer kcc.wg.Done()
+
+		for {
+			select {
+			case <-kcc.done:
+				log.Info("KafkaClusterConsumer stop, ListenTxn exit.")
+				return
+			case msg := <-kcc.queue:
+				kcc.checkThrottle()
+				log.Debugf("KafkaClusterConsumer ConsumeTxnMsg: GroupID: %s, Topic: %s, Partition: %v, Offset: %v, Timestamp: %v",
+					kcc.groupId, msg.Topic, msg.Partition, msg.Offset, msg.Timestamp)
+				atomic.AddInt32(&kcc.inflight, 1)
+				start := time.Now()
+				kcc.health.begin(start)
+				ok := kcc.consumeInTxn(producer, msg, transformFunc)
+				kcc.observeConsume(msg, time.Since(start))
+				kcc.health.end()
+				atomic.AddInt32(&kcc.inflight, -1)
+				if !ok {
+					return
+				}
+			}
+		}
+	}()
+}
+
+// transformFunc panic
+type txnPanicError struct {
+	p interface{}
+}
+
+func (e *txnPanicError) Error() string {
+	return fmt.Sprintf("transform panic: %v", e.p)
+}
+
+func callTransform(transformFunc func(m *sarama.ConsumerMessage, p *KafkaSyncProducer) error, msg *sarama.ConsumerMessage,
+	producer *KafkaSyncProducer) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			log.Errorf("KafkaClusterConsumer transform panic. Topic: %s, Partition: %v, Offset: %v, Panic: %v, Stack: %s.",
+				msg.Topic, msg.Partition, msg.Offset, p, debug.Stack())
+			err = &txnPanicError{p: p}
+		}
+	}()
+
+	return transformFunc(msg, producer)
+}
+
+// 返回false表示消费需要停止
+func (kcc *KafkaClusterConsumer) consumeInTxn(producer *KafkaSyncProducer, msg *sarama.ConsumerMessage,
+	transformFunc func(m *sarama.ConsumerMessage, p *KafkaSyncProducer) error) bool {
+	backoff := txnRetryBackoff
+	skip := false // transformFunc panic后只提交偏移量
+	for {
+		select {
+		case <-kcc.done:
+			return false
+		default:
+		}
+
+		err := producer.ExecTxn(func() error {
+			if !skip {
+				if err := resolveClaimChecks(kcc.claimStore, []*sarama.ConsumerMessage{msg}); err != nil {
+					return err
+				}
+				if err := callTransform(transformFunc, msg, producer); err != nil {
+					return err
+				}
+			}
+			return producer.AddMessageToTxn(msg, kcc.groupId)
+		})
+		if err == nil {
+			return true
+		}
+		kcc.observeError()
+		if producer.txnFatal() {
+			log.Errorf("KafkaClusterConsumer txn fatal error, ListenTxn exit. Topic: %s, Partition: %v, Offset: %v, Error: %#v.",
+				msg.Topic, msg.Partition, msg.Offset, err)
+			kcc.mu.Lock()
+			kcc.running = false
+			kcc.stopErr = err
+			kcc.mu.Unlock()
+			return false
+		}
+		if _, ok := err.(*txnPanicError); ok {
+			skip = true
+			continue
+		}
+
+		log.Errorf("KafkaClusterConsumer consume msg in txn failed, retry after %v. Topic: %s, Partition: %v, Offset: %v, Error: %#v.",
+			backoff, msg.Topic, msg.Partition, msg.Offset, err)
+		select {
+		case <-kcc.done:
+			return false
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > txnMaxRetryBackoff {
+			backoff = txnMaxRetryBackoff
+		}
+	}
+}
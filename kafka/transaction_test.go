@@ -0,0 +1,96 @@
+package kafka
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/Shopify/sarama"
+)
+
+func newTxnBroker(t *testing.T, endTxn ...interface{}) *sarama.MockBroker {
+	broker := sarama.NewMockBroker(t, 1)
+	broker.SetHandlerByMap(map[string]sarama.MockResponse{
+		"MetadataRequest": sarama.NewMockMetadataResponse(t).
+			SetBroker(broker.Addr(), broker.BrokerID()).
+			SetLeader("out", 0, broker.BrokerID()),
+		"FindCoordinatorRequest": sarama.NewMockFindCoordinatorResponse(t).
+			SetCoordinator(sarama.CoordinatorTransaction, "txn", broker).
+			SetCoordinator(sarama.CoordinatorGroup, "g", broker),
+		"InitProducerIDRequest": sarama.NewMockInitProducerIDResponse(t).SetProducerID(1000).SetProducerEpoch(0),
+		"AddPartitionsToTxnRequest": sarama.NewMockWrapper(&sarama.AddPartitionsToTxnResponse{
+			Errors: map[string][]*sarama.PartitionError{"out": {{Partition: 0, Err: sarama.ErrNoError}}},
+		}),
+		"ProduceRequest":         sarama.NewMockProduceResponse(t),
+		"AddOffsetsToTxnRequest": sarama.NewMockWrapper(&sarama.AddOffsetsToTxnResponse{Err: sarama.ErrNoError}),
+		"TxnOffsetCommitRequest": sarama.NewMockWrapper(&sarama.TxnOffsetCommitResponse{
+			Topics: map[string][]*sarama.PartitionError{"in": {{Partition: 0, Err: sarama.ErrNoError}}},
+		}),
+		"EndTxnRequest": sarama.NewMockSequence(endTxn...),
+	})
+
+	return broker
+}
+
+// 按顺序返回broker收到的EndTxn请求，true为提交
+func endTxnResults(broker *sarama.MockBroker) []bool {
+	results := make([]bool, 0)
+	for _, rr := range broker.History() {
+		if req, ok := rr.Request.(*sarama.EndTxnRequest); ok {
+			results = append(results, req.TransactionResult)
+		}
+	}
+
+	return results
+}
+
+func TestConsumeInTxn(t *testing.T) {
+	ok := &sarama.EndTxnResponse{Err: sarama.ErrNoError}
+	broker := newTxnBroker(t, ok, ok, ok, ok, &sarama.EndTxnResponse{Err: sarama.ErrProducerFenced})
+	defer broker.Close()
+
+	producer, err := NewKafkaSyncProducer(&ProducerConfig{Url: []string{broker.Addr()}, Version: "1.0.0", TransactionalId: "txn"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer producer.Close()
+	kcc := &KafkaClusterConsumer{groupId: "g", done: make(chan struct{}), running: true}
+
+	attempts := 0
+	transform := func(m *sarama.ConsumerMessage, p *KafkaSyncProducer) error {
+		attempts++
+		if err := p.SendMessage(&Message{Topic: "out", Value: sarama.ByteEncoder(m.Value)}); err != nil {
+			return err
+		}
+		switch string(m.Value) {
+		case "error":
+			if attempts == 1 {
+				return errors.New("transform failed")
+			}
+		case "panic":
+			panic("bad message")
+		}
+		return nil
+	}
+
+	// 提交；失败时回滚后重试；panic时回滚后跳过
+	for i, value := range []string{"ok", "error", "panic"} {
+		attempts = 0
+		msg := &sarama.ConsumerMessage{Topic: "in", Partition: 0, Offset: int64(i), Value: []byte(value)}
+		if !kcc.consumeInTxn(producer, msg, transform) {
+			t.Fatalf("consume %s stopped", value)
+		}
+	}
+	if got := fmt.Sprint(endTxnResults(broker)); got != "[true false true false]" {
+		t.Errorf("end txn = %s", got)
+	}
+
+	// 事务不可恢复时停止消费
+	msg := &sarama.ConsumerMessage{Topic: "in", Partition: 0, Offset: 3, Value: []byte("ok")}
+	if kcc.consumeInTxn(producer, msg, transform) {
+		t.Fatal("consume should stop on fatal error")
+	}
+	if status := kcc.Health(); status.Running || status.Error == "" {
+		t.Errorf("health = %+v", status)
+	}
+}
@@ -0,0 +1,119 @@
+package outbox
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jmoiron/sqlx"
+)
+
+const (
+	defaultTable           = "kafka_outbox"
+	defaultPollInterval    = time.Second
+	defaultBatchSize       = 100
+	defaultMaxRetry        = 10
+	defaultRetryBackoff    = time.Second
+	defaultMaxRetryBackoff = 5 * time.Minute
+	defaultRetainSent      = 24 * time.Hour
+	defaultCleanupInterval = 10 * time.Minute
+	defaultLeaseTimeout    = time.Minute
+)
+
+// 消息状态
+const (
+	StatusPending = 0 // 待发送
+	StatusSent    = 1 // 已发送
+	StatusFailed  = 2 // 超过最大重试次数，不再发送，同一个key后面的消息也不再发送，需要人工处理后调用Requeue或删除
+)
+
+// outbox表结构，%s替换为表名
+const CreateTableSql = `CREATE TABLE IF NOT EXISTS %s (
+  id            BIGINT        NOT NULL AUTO_INCREMENT,
+  topic         VARCHAR(255)  NOT NULL,
+  msg_key       VARCHAR(255)  NOT NULL DEFAULT '',
+  payload       MEDIUMBLOB    NOT NULL,
+  status        TINYINT       NOT NULL DEFAULT 0,
+  retry_count   INT           NOT NULL DEFAULT 0,
+  next_retry_at DATETIME      NOT NULL,
+  last_error    VARCHAR(1024) NOT NULL DEFAULT '',
+  created_at    DATETIME      NOT NULL,
+  sent_at       DATETIME      NULL,
+  PRIMARY KEY (id),
+  KEY idx_status_id (status, id),
+  KEY idx_topic_key_id (topic, msg_key, id),
+  KEY idx_status_sent_at (status, sent_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4`
+
+type Config struct {
+	Table           string        //outbox表名，默认kafka_outbox
+	PollInterval    time.Duration //轮询间隔，默认1秒
+	BatchSize       int           //每次轮询最多发送的消息数，默认100
+	MaxRetry        int           //最大重试次数，超过后标记为失败不再发送，默认10
+	RetryBackoff    time.Duration //首次重试间隔，之后每次翻倍，默认1秒
+	MaxRetryBackoff time.Duration //最大重试间隔，默认5分钟
+	RetainSent      time.Duration //已发送消息保留时间，默认24小时
+	CleanupInterval time.Duration //清理已发送消息的间隔，默认10分钟
+	LeaseTimeout    time.Duration //领取的消息多久未完成发送时可被重新领取（如relay进程崩溃），需要大于发送一批消息的时间，默认1分钟
+}
+
+func (cfg *Config) setDefaults() {
+	if cfg.Table == "" {
+		cfg.Table = defaultTable
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = defaultBatchSize
+	}
+	if cfg.MaxRetry <= 0 {
+		cfg.MaxRetry = defaultMaxRetry
+	}
+	if cfg.RetryBackoff <= 0 {
+		cfg.RetryBackoff = defaultRetryBackoff
+	}
+	if cfg.MaxRetryBackoff <= 0 {
+		cfg.MaxRetryBackoff = defaultMaxRetryBackoff
+	}
+	if cfg.RetainSent <= 0 {
+		cfg.RetainSent = defaultRetainSent
+	}
+	if cfg.CleanupInterval <= 0 {
+		cfg.CleanupInterval = defaultCleanupInterval
+	}
+	if cfg.LeaseTimeout <= 0 {
+		cfg.LeaseTimeout = defaultLeaseTimeout
+	}
+}
+
+// 在业务事务中写入待发送的消息，value使用json编码，与业务数据同时提交或回滚
+func Save(tx *sqlx.Tx, table, topic, key string, value interface{}) (int64, error) {
+	if tx == nil {
+		return 0, errors.New("transaction is nil")
+	}
+	if topic == "" {
+		return 0, errors.New("topic is empty")
+	}
+	if table == "" {
+		table = defaultTable
+	}
+
+	v, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("Invoke json marshal failed. Err: %#v.", err)
+		return 0, err
+	}
+
+	insertSql := fmt.Sprintf("INSERT INTO %s (topic, msg_key, payload, status, retry_count, next_retry_at, created_at) "+
+		"VALUES (?, ?, ?, ?, 0, NOW(), NOW())", table)
+	ret, err := tx.Exec(insertSql, topic, key, v, StatusPending)
+	if err != nil {
+		log.Errorf("Insert outbox message failed. Topic: %s, Key: %s, Error: %#v.", topic, key, err)
+		return 0, err
+	}
+
+	return ret.LastInsertId()
+}
@@ -0,0 +1,252 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/jmoiron/sqlx"
+	"jd.com/jvirt/jvirt-common/utils/db"
+)
+
+// 消息发送方，kafka.KafkaSyncProducer 满足该接口
+type Publisher interface {
+	Send(topic, key string, value interface{}) error
+}
+
+type outboxRow struct {
+	Id         int64  `db:"id"`
+	Topic      string `db:"topic"`
+	Key        string `db:"msg_key"`
+	Payload    []byte `db:"payload"`
+	RetryCount int    `db:"retry_count"`
+}
+
+// 轮询outbox表并将消息发送到kafka，同一个topic和key的消息按写入顺序发送，至少发送一次
+type Relay struct {
+	cfg       Config
+	client    *db.DBClient
+	publisher Publisher
+	running   bool
+	mu        sync.Mutex
+	wg        sync.WaitGroup
+	done      chan struct{}
+}
+
+func NewRelay(client *db.DBClient, publisher Publisher, cfg *Config) *Relay {
+	c := Config{}
+	if cfg != nil {
+		c = *cfg
+	}
+	c.setDefaults()
+
+	return &Relay{
+		cfg:       c,
+		client:    client,
+		publisher: publisher,
+	}
+}
+
+// 启动后台发送，Stop后可以再次Start
+func (r *Relay) Start() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.running {
+		return
+	}
+	r.running = true
+	done := make(chan struct{})
+	r.done = done
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		poll := time.NewTicker(r.cfg.PollInterval)
+		defer poll.Stop()
+		cleanup := time.NewTicker(r.cfg.CleanupInterval)
+		defer cleanup.Stop()
+
+		for {
+			select {
+			case <-done:
+				log.Info("Outbox relay stop, Relay exit.")
+				return
+			case <-poll.C:
+				if _, err := r.RelayOnce(); err != nil {
+					log.Errorf("Outbox relay failed. Error: %#v.", err)
+				}
+			case <-cleanup.C:
+				if _, err := r.Cleanup(); err != nil {
+					log.Errorf("Outbox cleanup failed. Error: %#v.", err)
+				}
+			}
+		}
+	}()
+}
+
+func (r *Relay) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.running {
+		return
+	}
+	close(r.done)
+	r.wg.Wait()
+	r.running = false
+}
+
+// 发送一批待发送的消息，返回发送成功的条数
+// 先在短事务中领取消息（推迟next_retry_at作为租约），提交后再发送，发送时不持有行锁
+// 多个Relay实例同时运行时同一条消息只会被一个实例领取
+func (r *Relay) RelayOnce() (int, error) {
+	rows, err := r.claim()
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	var firstErr error
+	// 同一个key前面的消息发送失败时，后面的消息不发送，释放后等前面的消息重试成功再发送，保证顺序
+	blocked := make(map[string]bool)
+	for _, row := range rows {
+		k := row.Topic + "/" + row.Key
+		if row.Key != "" && blocked[k] {
+			err = r.release(row)
+		} else if e := r.publisher.Send(row.Topic, row.Key, json.RawMessage(row.Payload)); e != nil {
+			log.Errorf("Outbox publish msg failed. Id: %d, Topic: %s, Key: %s, Error: %#v.", row.Id, row.Topic, row.Key, e)
+			blocked[k] = true
+			err = r.markFailed(row, e)
+		} else {
+			err = r.markSent(row)
+			sent++
+		}
+		// 更新状态失败时，租约到期后消息会被重新发送
+		if err != nil && firstErr == nil {
+			log.Errorf("Outbox update msg status failed. Id: %d, Error: %#v.", row.Id, err)
+			firstErr = err
+		}
+	}
+	if sent > 0 {
+		log.Debugf("Outbox relay send msg success. Count: %d.", sent)
+	}
+
+	return sent, firstErr
+}
+
+// 领取一批到期的消息：同一个key前面有失败或未到期（退避中、被其他实例领取）的消息时不领取
+func (r *Relay) claim() ([]*outboxRow, error) {
+	rows := make([]*outboxRow, 0)
+	err := r.client.Transaction(func(tx *sqlx.Tx) error {
+		querySql := fmt.Sprintf("SELECT a.id, a.topic, a.msg_key, a.payload, a.retry_count FROM %s a "+
+			"WHERE a.status = ? AND a.next_retry_at <= NOW() AND (a.msg_key = '' OR NOT EXISTS ("+
+			"SELECT 1 FROM %s b WHERE b.topic = a.topic AND b.msg_key = a.msg_key AND b.id < a.id "+
+			"AND (b.status = ? OR (b.status = ? AND b.next_retry_at > NOW())))) "+
+			"ORDER BY a.id LIMIT ? FOR UPDATE", r.cfg.Table, r.cfg.Table)
+		if err := tx.Select(&rows, querySql, StatusPending, StatusFailed, StatusPending, r.cfg.BatchSize); err != nil {
+			return err
+		}
+		if len(rows) == 0 {
+			return nil
+		}
+
+		ids := make([]int64, len(rows))
+		for i, row := range rows {
+			ids[i] = row.Id
+		}
+		updateSql, args, err := sqlx.In(fmt.Sprintf("UPDATE %s SET next_retry_at = DATE_ADD(NOW(), INTERVAL ? SECOND) "+
+			"WHERE id IN (?)", r.cfg.Table), int64(r.cfg.LeaseTimeout/time.Second), ids)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(updateSql, args...)
+
+		return err
+	})
+	if err != nil {
+		log.Errorf("Outbox claim msg failed. Error: %#v.", err)
+		return nil, err
+	}
+
+	return rows, nil
+}
+
+func (r *Relay) markSent(row *outboxRow) error {
+	updateSql := fmt.Sprintf("UPDATE %s SET status = ?, sent_at = NOW() WHERE id = ?", r.cfg.Table)
+	_, err := r.client.Update(updateSql, StatusSent, row.Id)
+
+	return err
+}
+
+// 释放领取的消息，由下一次轮询重新领取
+func (r *Relay) release(row *outboxRow) error {
+	updateSql := fmt.Sprintf("UPDATE %s SET next_retry_at = NOW() WHERE id = ?", r.cfg.Table)
+	_, err := r.client.Update(updateSql, row.Id)
+
+	return err
+}
+
+// 将失败的消息重新置为待发送，重试次数清零，同一个key后面的消息随之恢复发送
+func (r *Relay) Requeue(id int64) error {
+	updateSql := fmt.Sprintf("UPDATE %s SET status = ?, retry_count = 0, next_retry_at = NOW() WHERE id = ? AND status = ?", r.cfg.Table)
+	rows, err := r.client.Update(updateSql, StatusPending, id, StatusFailed)
+	if err != nil {
+		log.Errorf("Outbox requeue msg failed. Id: %d, Error: %#v.", id, err)
+		return err
+	}
+	if rows == 0 {
+		return fmt.Errorf("outbox msg %d not found or not failed", id)
+	}
+
+	return nil
+}
+
+// 记录失败并计算下次重试时间，超过最大重试次数后标记为失败
+func (r *Relay) markFailed(row *outboxRow, sendErr error) error {
+	status := StatusPending
+	if row.RetryCount+1 >= r.cfg.MaxRetry {
+		status = StatusFailed
+		log.Errorf("Outbox msg exceed max retry, give up. Id: %d, Topic: %s, Key: %s.", row.Id, row.Topic, row.Key)
+	}
+
+	lastErr := sendErr.Error()
+	if len(lastErr) > 1024 {
+		lastErr = lastErr[:1024]
+	}
+	updateSql := fmt.Sprintf("UPDATE %s SET status = ?, retry_count = retry_count + 1, "+
+		"next_retry_at = DATE_ADD(NOW(), INTERVAL ? SECOND), last_error = ? WHERE id = ?", r.cfg.Table)
+	_, err := r.client.Update(updateSql, status, int64(r.backoff(row.RetryCount)/time.Second), lastErr, row.Id)
+
+	return err
+}
+
+func (r *Relay) backoff(retryCount int) time.Duration {
+	d := r.cfg.RetryBackoff
+	for i := 0; i < retryCount && d < r.cfg.MaxRetryBackoff; i++ {
+		d *= 2
+	}
+	if d > r.cfg.MaxRetryBackoff {
+		d = r.cfg.MaxRetryBackoff
+	}
+	if d < time.Second {
+		d = time.Second
+	}
+
+	return d
+}
+
+// 删除超过保留时间的已发送消息，返回删除的条数
+func (r *Relay) Cleanup() (int64, error) {
+	deleteSql := fmt.Sprintf("DELETE FROM %s WHERE status = ? AND sent_at < DATE_SUB(NOW(), INTERVAL ? SECOND) LIMIT ?", r.cfg.Table)
+	rows, err := r.client.Update(deleteSql, StatusSent, int64(r.cfg.RetainSent/time.Second), r.cfg.BatchSize*10)
+	if err != nil {
+		return 0, err
+	}
+	if rows > 0 {
+		log.Debugf("Outbox cleanup sent msg. Count: %d.", rows)
+	}
+
+	return rows, nil
+}
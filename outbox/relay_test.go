@@ -0,0 +1,152 @@
+package outbox
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+	"jd.com/jvirt/jvirt-common/utils/db"
+)
+
+type fakePublisher struct {
+	sent []string
+	fail map[string]bool // 按payload指定发送失败的消息
+}
+
+func (p *fakePublisher) Send(topic, key string, value interface{}) error {
+	v, _ := value.(json.RawMessage)
+	if p.fail[string(v)] {
+		return errors.New("broker down")
+	}
+	p.sent = append(p.sent, string(v))
+	return nil
+}
+
+func newMockRelay(t *testing.T, publisher Publisher, cfg *Config) (*Relay, sqlmock.Sqlmock) {
+	conn, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	return NewRelay(db.NewDBClient(sqlx.NewDb(conn, "mysql")), publisher, cfg), mock
+}
+
+// 领取时只选择到期的消息，并排除同一个key前面有失败或未到期消息的消息
+func expectClaim(mock sqlmock.Sqlmock, rows *sqlmock.Rows, ids ...int64) {
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("WHERE a.status = ? AND a.next_retry_at <= NOW() AND (a.msg_key = '' OR NOT EXISTS (")+
+		".*"+regexp.QuoteMeta("AND (b.status = ? OR (b.status = ? AND b.next_retry_at > NOW())))) ORDER BY a.id LIMIT ? FOR UPDATE")).
+		WithArgs(StatusPending, StatusFailed, StatusPending, defaultBatchSize).
+		WillReturnRows(rows)
+	if len(ids) == 0 {
+		mock.ExpectCommit()
+		return
+	}
+	args := []driver.Value{int64(defaultLeaseTimeout / time.Second)}
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE kafka_outbox SET next_retry_at = DATE_ADD(NOW(), INTERVAL ? SECOND) WHERE id IN (")).
+		WithArgs(args...).
+		WillReturnResult(sqlmock.NewResult(0, int64(len(ids))))
+	mock.ExpectCommit()
+}
+
+func expectUpdate(mock sqlmock.Sqlmock, sql string, args ...driver.Value) {
+	mock.ExpectPrepare(regexp.QuoteMeta(sql)).ExpectExec().WithArgs(args...).WillReturnResult(sqlmock.NewResult(0, 1))
+}
+
+func TestRelayOrdering(t *testing.T) {
+	publisher := &fakePublisher{fail: map[string]bool{`"a1"`: true}}
+	relay, mock := newMockRelay(t, publisher, nil)
+
+	rows := sqlmock.NewRows([]string{"id", "topic", "msg_key", "payload", "retry_count"}).
+		AddRow(1, "orders", "a", []byte(`"a1"`), 0).
+		AddRow(2, "orders", "b", []byte(`"b1"`), 0).
+		AddRow(3, "orders", "a", []byte(`"a2"`), 0).
+		AddRow(4, "orders", "", []byte(`"n1"`), 0).
+		AddRow(5, "orders", "b", []byte(`"b2"`), 0)
+	expectClaim(mock, rows, 1, 2, 3, 4, 5)
+	// a1发送失败，退避后重试；a2被释放，等a1发送成功后再发送
+	expectUpdate(mock, "UPDATE kafka_outbox SET status = ?, retry_count = retry_count + 1", StatusPending, 1, "broker down", 1)
+	expectUpdate(mock, "UPDATE kafka_outbox SET status = ?, sent_at = NOW() WHERE id = ?", StatusSent, 2)
+	expectUpdate(mock, "UPDATE kafka_outbox SET next_retry_at = NOW() WHERE id = ?", 3)
+	expectUpdate(mock, "UPDATE kafka_outbox SET status = ?, sent_at = NOW() WHERE id = ?", StatusSent, 4)
+	expectUpdate(mock, "UPDATE kafka_outbox SET status = ?, sent_at = NOW() WHERE id = ?", StatusSent, 5)
+
+	sent, err := relay.RelayOnce()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent != 3 || fmt.Sprint(publisher.sent) != `["b1" "n1" "b2"]` {
+		t.Errorf("sent = %d, %v", sent, publisher.sent)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelayBackoff(t *testing.T) {
+	relay := NewRelay(nil, nil, &Config{RetryBackoff: time.Second, MaxRetryBackoff: 10 * time.Second})
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for i, w := range want {
+		if d := relay.backoff(i); d != w {
+			t.Errorf("backoff(%d) = %v, want %v", i, d, w)
+		}
+	}
+}
+
+func TestRelayFailedRow(t *testing.T) {
+	publisher := &fakePublisher{fail: map[string]bool{`"a3"`: true}}
+	relay, mock := newMockRelay(t, publisher, &Config{MaxRetry: 3})
+
+	// 第3次失败后标记为失败，不再重试
+	rows := sqlmock.NewRows([]string{"id", "topic", "msg_key", "payload", "retry_count"}).
+		AddRow(7, "orders", "a", []byte(`"a3"`), 2)
+	expectClaim(mock, rows, 7)
+	expectUpdate(mock, "UPDATE kafka_outbox SET status = ?, retry_count = retry_count + 1", StatusFailed, 4, "broker down", 7)
+	if sent, err := relay.RelayOnce(); err != nil || sent != 0 {
+		t.Fatalf("sent = %d, err = %v", sent, err)
+	}
+
+	// 失败的消息阻塞同一个key后面的消息，领取SQL排除了它们，没有可领取的消息
+	expectClaim(mock, sqlmock.NewRows([]string{"id", "topic", "msg_key", "payload", "retry_count"}))
+	if sent, err := relay.RelayOnce(); err != nil || sent != 0 {
+		t.Fatalf("sent = %d, err = %v", sent, err)
+	}
+
+	// 人工处理后重新发送
+	expectUpdate(mock, "UPDATE kafka_outbox SET status = ?, retry_count = 0, next_retry_at = NOW() WHERE id = ? AND status = ?",
+		StatusPending, 7, StatusFailed)
+	if err := relay.Requeue(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRelayRestart(t *testing.T) {
+	relay, mock := newMockRelay(t, &fakePublisher{}, &Config{PollInterval: 50 * time.Millisecond})
+	relay.Start()
+	relay.Stop()
+
+	// Stop后再次Start，继续轮询
+	expectClaim(mock, sqlmock.NewRows([]string{"id", "topic", "msg_key", "payload", "retry_count"}))
+	relay.Start()
+	defer relay.Stop()
+	deadline := time.Now().Add(time.Second)
+	for mock.ExpectationsWereMet() != nil && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}